@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+// artifactVersion is the version of the saved run format.
+const artifactVersion = 1
+
+// artifactHeader is the first line of a saved run.
+type artifactHeader struct {
+	Version   int                `json:"version"`
+	Started   time.Time          `json:"started"`
+	Endpoints []artifactEndpoint `json:"endpoints"`
+}
+
+type artifactEndpoint struct {
+	Name     string `json:"name,omitempty"`
+	Endpoint string `json:"endpoint"`
+}
+
+// artifactRecord is a line of a saved run for each completed request.
+type artifactRecord struct {
+	ID          requestID          `json:"id"`
+	Request     string             `json:"request,omitempty"`
+	RequestHash string             `json:"request_hash"`
+	Responses   []artifactResponse `json:"responses"`
+}
+
+type artifactResponse struct {
+	Endpoint int             `json:"endpoint"`
+	Hash     string          `json:"hash,omitempty"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Elapsed  float64         `json:"elapsed"` // Seconds
+}
+
+// Equal returns true if both responses have the same body or the same error.
+func (r artifactResponse) Equal(other artifactResponse) bool {
+	return r.Hash == other.Hash && r.Error == other.Error
+}
+
+// contentHash returns a hash of the body which is stable across JSON key
+// ordering and formatting.
+func contentHash(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if normalized, err := json.Marshal(v); err == nil {
+			body = normalized
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// artifactWriter saves every completed set of responses of a run, so that it
+// can be compared against separately-run tests later.
+type artifactWriter struct {
+	SaveBodies bool
+
+	f       *os.File
+	w       *bufio.Writer
+	enc     *json.Encoder
+	clients map[*Client]int
+	err     error
+}
+
+func newArtifactWriter(path string, clients Clients) (*artifactWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run artifact: %w", err)
+	}
+	aw := &artifactWriter{
+		f:       f,
+		w:       bufio.NewWriter(f),
+		clients: make(map[*Client]int, len(clients)),
+	}
+	aw.enc = json.NewEncoder(aw.w)
+
+	header := artifactHeader{
+		Version: artifactVersion,
+		Started: time.Now(),
+	}
+	for i, c := range clients {
+		aw.clients[c] = i
+		header.Endpoints = append(header.Endpoints, artifactEndpoint{Name: c.Name, Endpoint: c.Endpoint})
+	}
+	if err := aw.enc.Encode(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return aw, nil
+}
+
+// Write records a completed set of responses to the same request. Errors are
+// returned by Close.
+func (aw *artifactWriter) Write(resps []Response) {
+	if aw.err != nil || len(resps) == 0 {
+		return
+	}
+
+	record := artifactRecord{
+		ID:        resps[0].ID,
+		Responses: make([]artifactResponse, 0, len(resps)),
+	}
+	if req := resps[0].Request; req != nil {
+		record.Request = string(req.Line)
+		record.RequestHash = contentHash(req.Line)
+	}
+	for _, resp := range resps {
+		r := artifactResponse{
+			Endpoint: aw.clients[resp.client],
+			Elapsed:  resp.Elapsed.Seconds(),
+		}
+		if resp.Err != nil {
+			r.Error = resp.Err.Error()
+		}
+		if resp.Body != nil {
+			r.Hash = contentHash(resp.Body)
+			if aw.SaveBodies {
+				if json.Valid(resp.Body) {
+					r.Body = resp.Body
+				} else {
+					r.Body, _ = json.Marshal(string(resp.Body))
+				}
+			}
+		}
+		record.Responses = append(record.Responses, r)
+	}
+	sort.Slice(record.Responses, func(i, j int) bool {
+		return record.Responses[i].Endpoint < record.Responses[j].Endpoint
+	})
+
+	aw.err = aw.enc.Encode(record)
+}
+
+func (aw *artifactWriter) Close() error {
+	if err := aw.w.Flush(); err != nil && aw.err == nil {
+		aw.err = err
+	}
+	if err := aw.f.Close(); err != nil && aw.err == nil {
+		aw.err = err
+	}
+	return aw.err
+}
+
+// artifact is a saved run loaded from disk.
+type artifact struct {
+	Path    string
+	Header  artifactHeader
+	Records []artifactRecord
+}
+
+func loadArtifact(path string) (*artifact, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := artifact{Path: path}
+	dec := json.NewDecoder(bufio.NewReader(f))
+	if err := dec.Decode(&a.Header); err != nil {
+		return nil, fmt.Errorf("failed to read run artifact %q: %w", path, err)
+	}
+	if a.Header.Version != artifactVersion {
+		return nil, fmt.Errorf("unsupported run artifact version in %q: %d", path, a.Header.Version)
+	}
+	for {
+		var record artifactRecord
+		if err := dec.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read run artifact %q: %w", path, err)
+		}
+		a.Records = append(a.Records, record)
+	}
+	return &a, nil
+}
+
+// endpointName returns a human-readable name for the endpoint at index i.
+func (a *artifact) endpointName(i int) string {
+	e := a.Header.Endpoints[i]
+	if e.Name != "" {
+		return fmt.Sprintf("%s: %q", e.Name, e.Endpoint)
+	}
+	return fmt.Sprintf("%q", e.Endpoint)
+}
+
+// artifactComparison compares the responses of an endpoint in one run with
+// the responses of an endpoint in another run.
+type artifactComparison struct {
+	A, B         *artifact
+	EndpointA    int
+	EndpointB    int
+	MismatchedFn func(recordA, recordB artifactRecord)
+
+	matched    int // Requests present in both runs
+	mismatched int // Requests with different responses
+	onlyA      int // Requests only in run A
+	onlyB      int // Requests only in run B
+
+	errorsA, errorsB int
+	timingA, timingB histogram
+}
+
+func (c *artifactComparison) Compare() error {
+	if c.EndpointA < 0 || c.EndpointA >= len(c.A.Header.Endpoints) {
+		return fmt.Errorf("run %q has no endpoint %d", c.A.Path, c.EndpointA)
+	}
+	if c.EndpointB < 0 || c.EndpointB >= len(c.B.Header.Endpoints) {
+		return fmt.Errorf("run %q has no endpoint %d", c.B.Path, c.EndpointB)
+	}
+
+	// Requests are matched by their hash, duplicate requests in order
+	pending := map[string][]artifactRecord{}
+	for _, record := range c.B.Records {
+		pending[record.RequestHash] = append(pending[record.RequestHash], record)
+	}
+
+	for _, recordA := range c.A.Records {
+		queue := pending[recordA.RequestHash]
+		if len(queue) == 0 {
+			c.onlyA += 1
+			continue
+		}
+		recordB := queue[0]
+		pending[recordA.RequestHash] = queue[1:]
+
+		respA, okA := recordA.response(c.EndpointA)
+		respB, okB := recordB.response(c.EndpointB)
+		if !okA || !okB {
+			continue
+		}
+
+		c.matched += 1
+		c.timingA.Add(respA.Elapsed)
+		c.timingB.Add(respB.Elapsed)
+		if respA.Error != "" {
+			c.errorsA += 1
+		}
+		if respB.Error != "" {
+			c.errorsB += 1
+		}
+		if !respA.Equal(respB) {
+			c.mismatched += 1
+			if c.MismatchedFn != nil {
+				c.MismatchedFn(recordA, recordB)
+			}
+		}
+	}
+	for _, queue := range pending {
+		c.onlyB += len(queue)
+	}
+	return nil
+}
+
+func (r artifactRecord) response(endpoint int) (artifactResponse, bool) {
+	for _, resp := range r.Responses {
+		if resp.Endpoint == endpoint {
+			return resp, true
+		}
+	}
+	return artifactResponse{}, false
+}
+
+// change formats the relative change from a to b.
+func change(a, b float64) string {
+	if a == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%+0.1f%%)", (b-a)*100/a)
+}
+
+func (c *artifactComparison) Render(w io.Writer) error {
+	fmt.Fprintf(w, "\n%s vs %s\n", c.A.endpointName(c.EndpointA), c.B.endpointName(c.EndpointB))
+	fmt.Fprintf(w, "   Compared:   %d requests, %d only in %q, %d only in %q\n", c.matched, c.onlyA, c.A.Path, c.onlyB, c.B.Path)
+	fmt.Fprintf(w, "   Mismatched: %d\n", c.mismatched)
+	if c.matched == 0 {
+		return nil
+	}
+
+	avgA, avgB := c.timingA.Average(), c.timingB.Average()
+	fmt.Fprintf(w, "   Timing:     %0.4fs -> %0.4fs avg%s\n", avgA, avgB, change(avgA, avgB))
+	sdA, sdB := math.Sqrt(c.timingA.Variance()), math.Sqrt(c.timingB.Variance())
+	fmt.Fprintf(w, "               %0.4fs -> %0.4fs standard deviation%s\n", sdA, sdB, change(sdA, sdB))
+
+	fmt.Fprintf(w, "\n   Percentiles:\n")
+	percentilesA := c.timingA.Percentiles(percentileBuckets...)
+	percentilesB := c.timingB.Percentiles(percentileBuckets...)
+	for i, bucket := range percentileBuckets {
+		fmt.Fprintf(w, "     %g%% in %0.4fs -> %0.4fs%s\n", bucket, percentilesA[i], percentilesB[i], change(percentilesA[i], percentilesB[i]))
+	}
+
+	errRateA := float64(c.errorsA*100) / float64(c.matched)
+	errRateB := float64(c.errorsB*100) / float64(c.matched)
+	fmt.Fprintf(w, "\n   Errors: %0.2f%% -> %0.2f%%\n", errRateA, errRateB)
+	return nil
+}
+
+// CompareOptions contains the flag options of the compare command.
+type CompareOptions struct {
+	Args struct {
+		RunA string `positional-arg-name:"run-a" description:"Run saved with --save-run to compare from"`
+		RunB string `positional-arg-name:"run-b" description:"Run saved with --save-run to compare to"`
+	} `positional-args:"yes" required:"yes"`
+
+	EndpointA []int  `long:"endpoint-a" description:"Endpoint number in run-a to compare, defaults to all endpoints in order."`
+	EndpointB []int  `long:"endpoint-b" description:"Endpoint number in run-b to compare, paired with --endpoint-a."`
+	Verbose   []bool `long:"verbose" short:"v" description:"Show mismatched responses."`
+}
+
+// compareMain implements the "versus compare run-a run-b" command, which
+// compares the responses and timing of two saved runs.
+func compareMain(args []string) error {
+	options := CompareOptions{}
+	parser := flags.NewParser(&options, flags.Default)
+	parser.Usage = "compare [OPTIONS]"
+	if _, err := parser.ParseArgs(args); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	a, err := loadArtifact(options.Args.RunA)
+	if err != nil {
+		return err
+	}
+	b, err := loadArtifact(options.Args.RunB)
+	if err != nil {
+		return err
+	}
+
+	endpointsA, endpointsB := options.EndpointA, options.EndpointB
+	if len(endpointsA) == 0 && len(endpointsB) == 0 {
+		// Pair endpoints in order
+		for i := 0; i < len(a.Header.Endpoints) && i < len(b.Header.Endpoints); i++ {
+			endpointsA = append(endpointsA, i)
+			endpointsB = append(endpointsB, i)
+		}
+	}
+	if len(endpointsA) != len(endpointsB) {
+		return fmt.Errorf("--endpoint-a and --endpoint-b must be paired")
+	}
+
+	w := os.Stdout
+	fmt.Fprintf(w, "Comparing %q (%d requests, %s) with %q (%d requests, %s):\n",
+		a.Path, len(a.Records), a.Header.Started.Format(time.RFC3339),
+		b.Path, len(b.Records), b.Header.Started.Format(time.RFC3339),
+	)
+	for i := range endpointsA {
+		c := artifactComparison{
+			A:         a,
+			B:         b,
+			EndpointA: endpointsA[i],
+			EndpointB: endpointsB[i],
+		}
+		if len(options.Verbose) > 0 {
+			c.MismatchedFn = func(recordA, recordB artifactRecord) {
+				respA, _ := recordA.response(c.EndpointA)
+				respB, _ := recordB.response(c.EndpointB)
+				logger.Info().Str("request", recordA.Request).Msgf("mismatched responses:\n\t%s\n\t%s", respA.String(), respB.String())
+			}
+		}
+		if err := c.Compare(); err != nil {
+			return err
+		}
+		if err := c.Render(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r artifactResponse) String() string {
+	if r.Error != "" {
+		return "error: " + r.Error
+	}
+	if r.Body != nil {
+		return string(r.Body)
+	}
+	return "hash: " + r.Hash
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArtifact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "versus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clients, err := NewClients([]string{"noop://foo"}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(name string, bodies map[string]string) *artifact {
+		path := filepath.Join(dir, name)
+		aw, err := newArtifactWriter(path, clients)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aw.SaveBodies = true
+		id := requestID(0)
+		for _, line := range []string{"a", "b", "c", "a"} {
+			body, ok := bodies[line]
+			if !ok {
+				continue
+			}
+			id += 1
+			resp := Response{
+				client:  clients[0],
+				Request: &Request{ID: id, Line: []byte(line)},
+				ID:      id,
+				Body:    []byte(body),
+				Elapsed: time.Second,
+			}
+			if body == "" {
+				resp.Body = nil
+				resp.Err = errors.New("oops")
+			}
+			aw.Write([]Response{resp})
+		}
+		if err := aw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		a, err := loadArtifact(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	a := save("a.jsonl", map[string]string{"a": `{"x":1,"y":2}`, "b": `"foo"`, "c": `"bar"`})
+	b := save("b.jsonl", map[string]string{"a": `{"y":2, "x":1}`, "b": ""})
+
+	if got, want := len(a.Records), 4; got != want {
+		t.Fatalf("records got: %d; want: %d", got, want)
+	}
+	if got, want := string(a.Records[1].Responses[0].Body), `"foo"`; got != want {
+		t.Errorf("body got: %s; want: %s", got, want)
+	}
+
+	var mismatched []string
+	c := artifactComparison{
+		A: a,
+		B: b,
+		MismatchedFn: func(recordA, recordB artifactRecord) {
+			mismatched = append(mismatched, recordA.Request)
+		},
+	}
+	if err := c.Compare(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := c.matched, 3; got != want {
+		t.Errorf("matched got: %d; want: %d", got, want)
+	}
+	if got, want := c.onlyA, 1; got != want {
+		t.Errorf("only in a got: %d; want: %d", got, want)
+	}
+	if got, want := c.onlyB, 0; got != want {
+		t.Errorf("only in b got: %d; want: %d", got, want)
+	}
+	if got, want := c.errorsB, 1; got != want {
+		t.Errorf("errors in b got: %d; want: %d", got, want)
+	}
+	if len(mismatched) != 1 || mismatched[0] != "b" {
+		t.Errorf("mismatched got: %q; want: [b]", mismatched)
+	}
+
+	if err := (&artifactComparison{A: a, B: b, EndpointB: 1}).Compare(); err == nil {
+		t.Error("expected error for missing endpoint")
+	}
+}
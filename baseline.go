@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// baselineRequest is a trivial request, so that its round trip time is mostly
+// network latency.
+var baselineRequest = []byte(`{"jsonrpc":"2.0","id":1,"method":"web3_clientVersion","params":[]}`)
+
+// baseline is the latency of an endpoint before the run, to tell the network
+// latency apart from the time spent handling requests.
+type baseline struct {
+	connect histogram // TCP connect times, in seconds
+	request histogram // Round trip times of a trivial request, in seconds
+}
+
+// Offset is the lowest round trip time of the trivial request, which is
+// subtracted from timings when adjusting for the baseline.
+func (b *baseline) Offset() time.Duration {
+	return time.Duration(b.request.Min() * float64(time.Second))
+}
+
+// dialAddress returns the host and port to connect to for an endpoint, or
+// false if it's not a network endpoint.
+func dialAddress(endpoint string) (string, bool) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false
+	}
+	port := ""
+	switch strings.SplitN(u.Scheme, "+", 2)[0] {
+	case "http", "ws":
+		port = "80"
+	case "https", "wss":
+		port = "443"
+	default:
+		return "", false
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port), true
+}
+
+// probeBaseline measures the baseline latency of an endpoint, with samples of
+// the time to connect and the round trip time of a trivial request. Requests
+// are sent over a warm connection, so the first one isn't counted.
+func probeBaseline(ctx context.Context, c *Client, samples int) (*baseline, error) {
+	b := &baseline{}
+	addr, isNetwork := dialAddress(c.Endpoint)
+	dialer := net.Dialer{Timeout: c.Timeout}
+	for i := 0; isNetwork && i < samples; i++ {
+		started := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect: %w", err)
+		}
+		b.connect.Add(time.Since(started).Seconds())
+		conn.Close()
+	}
+
+	t, err := NewTransport(c.Endpoint, c.Timeout, c.Header)
+	if err != nil {
+		return nil, err
+	}
+	defer closeTransport(t)
+	for i := 0; i <= samples; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		started := time.Now()
+		if _, err := t.Send(baselineRequest); err != nil {
+			return nil, fmt.Errorf("failed to send baseline request: %w", err)
+		}
+		if i > 0 {
+			b.request.Add(time.Since(started).Seconds())
+		}
+	}
+	return b, nil
+}
+
+// probeBaselines measures the baseline latency of every endpoint at once, and
+// sets it on their stats.
+func probeBaselines(ctx context.Context, clients Clients, samples int, subtract bool) error {
+	errs := make([]error, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		i, c := i, c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := probeBaseline(ctx, c, samples)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to probe baseline of %s: %w", c.Endpoint, err)
+				return
+			}
+			logger.Debug().Str("endpoint", c.Endpoint).Dur("offset", b.Offset()).Float64("connect", b.connect.Min()).Msg("probed baseline")
+			c.Stats.SetBaseline(b, subtract)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetBaseline sets the baseline latency of the endpoint. If subtract is set,
+// timings are also counted with the baseline offset subtracted.
+func (stats *clientStats) SetBaseline(b *baseline, subtract bool) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.baseline = b
+	stats.subtractBaseline = subtract
+	stats.adjusted.Precision = stats.timing.Precision
+}
+
+// countAdjusted counts the timing with the baseline offset subtracted, must be
+// called with the lock held. Timings below the baseline count as zero.
+func (stats *clientStats) countAdjusted(elapsed time.Duration) {
+	if !stats.subtractBaseline {
+		return
+	}
+	adjusted := elapsed - stats.baseline.Offset()
+	if adjusted < 0 {
+		adjusted = 0
+	}
+	stats.adjusted.Add(adjusted.Seconds())
+}
+
+func (stats *clientStats) renderBaseline(w io.Writer) {
+	b := stats.baseline
+	if b == nil {
+		return
+	}
+	fmt.Fprintf(w, "\n   Baseline:   %0.4fs request min, %0.4fs avg over %d probes\n", b.request.Min(), b.request.Average(), b.request.Len())
+	if b.connect.Len() > 0 {
+		fmt.Fprintf(w, "               %0.4fs connect min, %0.4fs avg\n", b.connect.Min(), b.connect.Average())
+	}
+	if !stats.subtractBaseline || stats.adjusted.Len() == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n   Adjusted:   %0.4fs avg, %0.4fs min, %0.4fs max, with the %0.4fs baseline subtracted\n", stats.adjusted.Average(), stats.adjusted.Min(), stats.adjusted.Max(), b.Offset().Seconds())
+	renderPercentiles(w, &stats.adjusted)
+}
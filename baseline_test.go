@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDialAddress(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		ok       bool
+	}{
+		{"http://localhost:8545/", "localhost:8545", true},
+		{"https://mainnet.infura.io/v3/foo", "mainnet.infura.io:443", true},
+		{"ws://localhost/", "localhost:80", true},
+		{"wss+pipeline://[::1]:8546/", "[::1]:8546", true},
+		{"https+get://example.com/", "example.com:443", true},
+		{"noop://foo", "", false},
+	}
+
+	for _, tc := range tests {
+		got, ok := dialAddress(tc.endpoint)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%s: got: %q %t; want: %q %t", tc.endpoint, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestProbeBaseline(t *testing.T) {
+	var mu sync.Mutex
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		methods = append(methods, jsonrpcMethod(body))
+		mu.Unlock()
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"Geth/v1.9.0"}`))
+	}))
+	defer srv.Close()
+
+	clients, err := NewClients([]string{srv.URL, "noop://foo"}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := probeBaselines(context.Background(), clients, 3, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first request warms up the connection, and isn't counted
+	if len(methods) != 4 || methods[0] != "web3_clientVersion" {
+		t.Errorf("got requests: %v; want 4 web3_clientVersion", methods)
+	}
+	b := clients[0].Stats.baseline
+	if b == nil || b.connect.Len() != 3 || b.request.Len() != 3 || b.Offset() <= 0 {
+		t.Fatalf("got baseline: %+v; want 3 connect and request samples", b)
+	}
+	if b := clients[1].Stats.baseline; b == nil || b.connect.Len() != 0 || b.request.Len() != 3 {
+		t.Errorf("got noop baseline: %+v; want only request samples", b)
+	}
+}
+
+func TestAdjustedTiming(t *testing.T) {
+	var stats clientStats
+	b := &baseline{}
+	b.request.Add(0.010)
+	b.request.Add(0.020)
+	stats.SetBaseline(b, true)
+
+	stats.Count("eth_call", nil, 5*time.Millisecond)
+	stats.Count("eth_call", nil, 30*time.Millisecond)
+
+	// Timing below the baseline is clamped to zero
+	if got, want := stats.adjusted.Min(), 0.0; got != want {
+		t.Errorf("got min: %g; want: %g", got, want)
+	}
+	if got, want := stats.adjusted.Max(), 0.020; math.Abs(got-want) > 1e-9 {
+		t.Errorf("got max: %g; want: %g", got, want)
+	}
+	if got, want := stats.timing.Max(), 0.030; math.Abs(got-want) > 1e-9 {
+		t.Errorf("got unadjusted max: %g; want: %g", got, want)
+	}
+}
+
+func TestProbeBaselineCloses(t *testing.T) {
+	// Probing doesn't leave websocket connections open
+	srv, open := wsConnServer(t, echoResult(`"Geth/v1.9.0"`))
+	defer srv.Close()
+
+	url := strings.TrimPrefix(srv.URL, "http")
+	for _, endpoint := range []string{"ws" + url, "ws+pipeline" + url} {
+		clients, err := NewClients([]string{endpoint}, 1, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := probeBaselines(context.Background(), clients, 2, false); err != nil {
+			t.Fatal(err)
+		}
+		waitClosed(t, open)
+	}
+}
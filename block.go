@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// blockNumber queries the head block number of an endpoint.
+func blockNumber(t Transport) (uint64, error) {
+	body, err := t.Send([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`))
+	if err != nil {
+		return 0, err
+	}
+	var resp struct {
+		Result string `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0, fmt.Errorf("invalid eth_blockNumber response: %w", err)
+	}
+	if resp.Error != nil {
+		return 0, fmt.Errorf("eth_blockNumber failed: %s", resp.Error.Message)
+	}
+	return parseQuantity(resp.Result)
+}
+
+// parseQuantity parses a hex quantity like "0x1b4", or a decimal number.
+func parseQuantity(s string) (uint64, error) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strconv.ParseUint(s[2:], 16, 64)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// blockParams are the positions of the block parameter of methods, for
+// methods where it can be a block tag.
+var blockParams = map[string]struct {
+	Index    int
+	Optional bool // Defaults to "latest" when omitted
+}{
+	"eth_getBalance":                          {1, true},
+	"eth_getCode":                             {1, true},
+	"eth_getTransactionCount":                 {1, true},
+	"eth_getStorageAt":                        {2, true},
+	"eth_call":                                {1, true},
+	"eth_estimateGas":                         {1, true},
+	"eth_getProof":                            {2, true},
+	"eth_feeHistory":                          {1, false},
+	"eth_getBlockByNumber":                    {0, false},
+	"eth_getBlockReceipts":                    {0, false},
+	"eth_getBlockTransactionCountByNumber":    {0, false},
+	"eth_getUncleCountByBlockNumber":          {0, false},
+	"eth_getTransactionByBlockNumberAndIndex": {0, false},
+	"eth_getUncleByBlockNumberAndIndex":       {0, false},
+}
+
+// isRacyTag returns true if the block parameter refers to a block that moves
+// with the chain head.
+func isRacyTag(param json.RawMessage) bool {
+	var tag string
+	if err := json.Unmarshal(param, &tag); err != nil {
+		return false
+	}
+	return tag == "latest" || tag == "pending"
+}
+
+// pinBlock rewrites the "latest" and "pending" block tags of a JSON-RPC
+// request (or each request of a batch) to the block number, including block
+// parameters that default to "latest" when omitted. Returns the line as-is if
+// nothing was rewritten.
+func pinBlock(line []byte, block uint64) []byte {
+	number, _ := json.Marshal(fmt.Sprintf("0x%x", block))
+
+	var batch []map[string]json.RawMessage
+	if err := json.Unmarshal(line, &batch); err == nil {
+		changed := false
+		for _, msg := range batch {
+			if pinRequest(msg, number) {
+				changed = true
+			}
+		}
+		if !changed {
+			return line
+		}
+		out, err := json.Marshal(batch)
+		if err != nil {
+			return line
+		}
+		return out
+	}
+
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(line, &msg); err != nil || !pinRequest(msg, number) {
+		return line
+	}
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return line
+	}
+	return out
+}
+
+// pinRequest rewrites the block parameter of a decoded request, returns true
+// if it was changed.
+func pinRequest(msg map[string]json.RawMessage, number json.RawMessage) bool {
+	var method string
+	if err := json.Unmarshal(msg["method"], &method); err != nil {
+		return false
+	}
+	var params []json.RawMessage
+	if len(msg["params"]) > 0 {
+		if err := json.Unmarshal(msg["params"], &params); err != nil {
+			return false
+		}
+	}
+
+	changed := false
+	if method == "eth_getLogs" || method == "eth_newFilter" {
+		changed = pinFilter(params, number)
+	} else if p, ok := blockParams[method]; !ok {
+		return false
+	} else if p.Index < len(params) {
+		if isRacyTag(params[p.Index]) {
+			params[p.Index] = number
+			changed = true
+		}
+	} else if p.Optional && p.Index == len(params) {
+		params = append(params, number)
+		changed = true
+	}
+	if !changed {
+		return false
+	}
+
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return false
+	}
+	msg["params"] = encoded
+	return true
+}
+
+// pinFilter rewrites the fromBlock and toBlock of a log filter, which default
+// to "latest" when omitted. Filters by block hash are left as-is.
+func pinFilter(params []json.RawMessage, number json.RawMessage) bool {
+	if len(params) == 0 {
+		return false
+	}
+	var filter map[string]json.RawMessage
+	if err := json.Unmarshal(params[0], &filter); err != nil {
+		return false
+	}
+	if _, ok := filter["blockHash"]; ok {
+		return false
+	}
+	changed := false
+	for _, key := range []string{"fromBlock", "toBlock"} {
+		if value, ok := filter[key]; !ok || isRacyTag(value) {
+			filter[key] = number
+			changed = true
+		}
+	}
+	if !changed {
+		return false
+	}
+	encoded, err := json.Marshal(filter)
+	if err != nil {
+		return false
+	}
+	params[0] = encoded
+	return true
+}
+
+// blockPinner pins requests to a block number, either fixed or the lowest
+// head block of every endpoint, so that endpoints at different chain heads
+// respond about the same block.
+type blockPinner struct {
+	mu    sync.Mutex
+	block uint64
+
+	interval   time.Duration // Refresh interval of the head block, if any
+	transports []Transport
+	endpoints  []string
+}
+
+// newBlockPinner pins requests to a fixed block number, or with "head" to the
+// lowest head block of the clients, which is queried once before returning and
+// again on every refresh interval.
+func newBlockPinner(block string, refresh string, clients Clients) (*blockPinner, error) {
+	if block != "head" {
+		n, err := parseQuantity(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block to pin, must be a number or \"head\": %s", block)
+		}
+		return &blockPinner{block: n}, nil
+	}
+
+	interval, err := time.ParseDuration(refresh)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pin refresh: %w", err)
+	}
+	p := &blockPinner{interval: interval}
+	for _, c := range clients {
+		t, err := NewTransport(c.Endpoint, c.Timeout, c.Header)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.transports = append(p.transports, t)
+		p.endpoints = append(p.endpoints, c.Endpoint)
+	}
+	if err := p.Refresh(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close closes the transports used to refresh the head block, must not be
+// called while Run is refreshing.
+func (p *blockPinner) Close() {
+	for _, t := range p.transports {
+		closeTransport(t)
+	}
+}
+
+// Block returns the current pinned block number.
+func (p *blockPinner) Block() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.block
+}
+
+// Refresh pins the lowest head block of every endpoint.
+func (p *blockPinner) Refresh() error {
+	var lowest uint64
+	for i, t := range p.transports {
+		n, err := blockNumber(t)
+		if err != nil {
+			return fmt.Errorf("failed to get block number of %s: %w", p.endpoints[i], err)
+		}
+		if i == 0 || n < lowest {
+			lowest = n
+		}
+	}
+	p.mu.Lock()
+	p.block = lowest
+	p.mu.Unlock()
+	logger.Debug().Uint64("block", lowest).Msg("pinned block")
+	return nil
+}
+
+// Refreshing returns true if the pinned block is the head block, which must
+// be refreshed with Run.
+func (p *blockPinner) Refreshing() bool {
+	return p.interval > 0
+}
+
+// Run refreshes the pinned head block every interval until ctx is done.
+// Failed refreshes keep the previous block.
+func (p *blockPinner) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Refresh(); err != nil {
+				logger.Warn().Err(err).Msg("failed to refresh pinned block")
+			}
+		}
+	}
+}
+
+// Pin returns a lineScanner with each line pinned to the current block.
+func (p *blockPinner) Pin(scanner lineScanner) lineScanner {
+	return &pinnedScanner{scanner, p}
+}
+
+type pinnedScanner struct {
+	lineScanner
+	pinner *blockPinner
+}
+
+func (s *pinnedScanner) Bytes() []byte {
+	return pinBlock(s.lineScanner.Bytes(), s.pinner.Block())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPinBlock(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`{"id":1,"method":"eth_getBalance","params":["0xabc","latest"]}`, `{"id":1,"method":"eth_getBalance","params":["0xabc","0x64"]}`},
+		{`{"id":1,"method":"eth_getBalance","params":["0xabc"]}`, `{"id":1,"method":"eth_getBalance","params":["0xabc","0x64"]}`},
+		{`{"id":1,"method":"eth_getBalance","params":["0xabc","0x1"]}`, `{"id":1,"method":"eth_getBalance","params":["0xabc","0x1"]}`},
+		{`{"id":1,"method":"eth_getStorageAt","params":["0xabc","0x0","pending"]}`, `{"id":1,"method":"eth_getStorageAt","params":["0xabc","0x0","0x64"]}`},
+		{`{"id":1,"method":"eth_getBlockByNumber","params":["latest",false]}`, `{"id":1,"method":"eth_getBlockByNumber","params":["0x64",false]}`},
+		{`{"id":1,"method":"eth_getBlockByNumber","params":["earliest",false]}`, `{"id":1,"method":"eth_getBlockByNumber","params":["earliest",false]}`},
+		{`{"id":1,"method":"eth_getLogs","params":[{"fromBlock":"0x1","toBlock":"latest"}]}`, `{"id":1,"method":"eth_getLogs","params":[{"fromBlock":"0x1","toBlock":"0x64"}]}`},
+		{`{"id":1,"method":"eth_getLogs","params":[{"address":"0xabc"}]}`, `{"id":1,"method":"eth_getLogs","params":[{"address":"0xabc","fromBlock":"0x64","toBlock":"0x64"}]}`},
+		{`{"id":1,"method":"eth_getLogs","params":[{"blockHash":"0xdef"}]}`, `{"id":1,"method":"eth_getLogs","params":[{"blockHash":"0xdef"}]}`},
+		{`{"id":1,"method":"eth_blockNumber","params":[]}`, `{"id":1,"method":"eth_blockNumber","params":[]}`},
+		{`[{"id":1,"method":"eth_call","params":[{"to":"0xabc"}]},{"id":2,"method":"eth_chainId"}]`, `[{"id":1,"method":"eth_call","params":[{"to":"0xabc"},"0x64"]},{"id":2,"method":"eth_chainId"}]`},
+		{`not json`, `not json`},
+	}
+
+	for _, tc := range tests {
+		// Keys can be reordered when rewritten
+		assertJSONEqual(t, tc.line, string(pinBlock([]byte(tc.line), 100)), tc.want)
+	}
+}
+
+// assertJSONEqual fails the test unless got is the same JSON document as want,
+// including the envelope.
+func assertJSONEqual(t *testing.T, name, got, want string) {
+	t.Helper()
+	if got == want {
+		return
+	}
+	gotVal, err := decodeJSON([]byte(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantVal, err := decodeJSON([]byte(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffValues(gotVal, wantVal)) > 0 {
+		t.Errorf("%s: got: %s; want: %s", name, got, want)
+	}
+}
+
+func TestBlockPinner(t *testing.T) {
+	var servers []*httptest.Server
+	for _, head := range []string{"0x10", "0xf", "0x11"} {
+		head := head
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + head + `"}`))
+		}))
+		defer srv.Close()
+		servers = append(servers, srv)
+	}
+
+	clients, err := NewClients([]string{servers[0].URL, servers[1].URL, servers[2].URL}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newBlockPinner("head", "1m", clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Block(), uint64(15); got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+	if !p.Refreshing() {
+		t.Error("head pinner is not refreshing")
+	}
+
+	p, err = newBlockPinner("0x20", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Block(), uint64(32); got != want || p.Refreshing() {
+		t.Errorf("got: %d %t; want: %d false", got, p.Refreshing(), want)
+	}
+	if _, err := newBlockPinner("latest", "", nil); err == nil {
+		t.Error("expected error for invalid block")
+	}
+}
+
+func TestBlockPinnerClose(t *testing.T) {
+	srv, open := wsConnServer(t, echoResult(`"0x10"`))
+	defer srv.Close()
+
+	clients, err := NewClients([]string{"ws" + strings.TrimPrefix(srv.URL, "http")}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newBlockPinner("head", "1m", clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	waitClosed(t, open)
+}
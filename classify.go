@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxMismatchGroups bounds the number of distinct mismatch signatures,
+	// further signatures are counted together.
+	maxMismatchGroups = 100
+	// maxSignaturePaths bounds the number of differing paths in a signature.
+	maxSignaturePaths = 20
+)
+
+// mismatchGroup counts mismatches with the same signature: the method, how the
+// endpoints disagree, and which paths differ.
+type mismatchGroup struct {
+	Method    string
+	Partition [][]int  // Endpoint indexes, grouped by equivalent responses
+	Paths     []string // Differing JSON pointer paths, with array indexes as *
+	Count     int
+	Example   Responses // First mismatched response set
+}
+
+// mismatchGroups classifies mismatched response sets by their signature.
+type mismatchGroups struct {
+	groups map[string]*mismatchGroup
+	other  int // Mismatches beyond maxMismatchGroups signatures
+}
+
+// Add classifies a mismatched response set, ordered by endpoint index.
+func (g *mismatchGroups) Add(c *comparator, resps Responses, index func(Response) int) {
+	var method string
+	if resps[0].Request != nil {
+		method = resps[0].Request.Method
+	}
+
+	classes := partitionResponses(c, resps)
+	partition := make([][]int, 0, len(classes))
+	for _, class := range classes {
+		indexes := make([]int, 0, len(class))
+		for _, resp := range class {
+			indexes = append(indexes, index(resp))
+		}
+		partition = append(partition, indexes)
+	}
+
+	paths := map[string]struct{}{}
+	for _, class := range classes[1:] {
+		for _, path := range mismatchPaths(c, method, classes[0][0], class[0]) {
+			paths[path] = struct{}{}
+		}
+	}
+	group := mismatchGroup{
+		Method:    method,
+		Partition: partition,
+		Paths:     make([]string, 0, len(paths)),
+	}
+	for path := range paths {
+		group.Paths = append(group.Paths, path)
+	}
+	sort.Strings(group.Paths)
+	if len(group.Paths) > maxSignaturePaths {
+		group.Paths = append(group.Paths[:maxSignaturePaths], "...")
+	}
+
+	key := group.signature()
+	if existing, ok := g.groups[key]; ok {
+		existing.Count += 1
+		return
+	}
+	if len(g.groups) >= maxMismatchGroups {
+		g.other += 1
+		return
+	}
+	if g.groups == nil {
+		g.groups = map[string]*mismatchGroup{}
+	}
+	group.Count = 1
+	group.Example = append(Responses(nil), resps...)
+	g.groups[key] = &group
+}
+
+// partitionResponses groups responses which are equivalent to each other, in
+// order of their first response.
+func partitionResponses(c *comparator, resps []Response) [][]Response {
+	var classes [][]Response
+	for _, resp := range resps {
+		found := false
+		for i, class := range classes {
+			if c.Equal(class[0], resp) {
+				classes[i] = append(classes[i], resp)
+				found = true
+				break
+			}
+		}
+		if !found {
+			classes = append(classes, []Response{resp})
+		}
+	}
+	return classes
+}
+
+// mismatchPaths returns the generalized paths that differ between two
+// responses, or a placeholder for differing errors and bodies that aren't JSON.
+func mismatchPaths(c *comparator, method string, a, b Response) []string {
+	if a.Err != nil || b.Err != nil {
+		return []string{"(error)"}
+	}
+	entries, ok := c.Diff(method, a.Body, b.Body)
+	if !ok {
+		return []string{"(body)"}
+	}
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		paths = append(paths, generalizePath(entry.Path))
+	}
+	return paths
+}
+
+// generalizePath replaces array indexes in a JSON pointer with *, so that the
+// same field of different array elements has the same path.
+func generalizePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if _, err := strconv.Atoi(part); err == nil {
+			parts[i] = "*"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (group *mismatchGroup) signature() string {
+	return group.Method + "\x00" + group.Endpoints() + "\x00" + strings.Join(group.Paths, "\x00")
+}
+
+// Endpoints describes how the endpoints disagree, like "0, 2 != 1".
+func (group *mismatchGroup) Endpoints() string {
+	parts := make([]string, 0, len(group.Partition))
+	for _, indexes := range group.Partition {
+		s := make([]string, 0, len(indexes))
+		for _, i := range indexes {
+			s = append(s, strconv.Itoa(i))
+		}
+		parts = append(parts, strings.Join(s, ", "))
+	}
+	return strings.Join(parts, " != ")
+}
+
+// Sorted returns the groups by descending count.
+func (g *mismatchGroups) Sorted() []*mismatchGroup {
+	groups := make([]*mismatchGroup, 0, len(g.groups))
+	for _, group := range g.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].signature() < groups[j].signature()
+	})
+	return groups
+}
+
+// Render writes each group with an example, indented for the report summary.
+func (g *mismatchGroups) Render(w io.Writer, c *comparator) {
+	if len(g.groups) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "   Mismatch groups:\n")
+	format := diffFormat{}
+	for i, group := range g.Sorted() {
+		method := group.Method
+		if method == "" {
+			method = "(unknown method)"
+		}
+		fmt.Fprintf(w, "   %d. %d× %s, endpoints %s: %s\n", i+1, group.Count, method, group.Endpoints(), strings.Join(group.Paths, " "))
+		if req := group.Example[0].Request; req != nil {
+			fmt.Fprintf(w, "      Example: %s\n", format.truncate(string(req.Line)))
+		}
+		diff := strings.TrimSpace(group.Example.Diff(c, format))
+		fmt.Fprintf(w, "      %s\n", strings.Replace(diff, "\n", "\n      ", -1))
+	}
+	if g.other > 0 {
+		fmt.Fprintf(w, "   ... and %d mismatches in other groups\n", g.other)
+	}
+}
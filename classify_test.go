@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMismatchGroups(t *testing.T) {
+	clients, err := NewClients([]string{
+		"noop://foo",
+		"noop://bar",
+		"noop://baz",
+	}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := report{Clients: clients}
+	r.init()
+
+	id := requestID(0)
+	send := func(method string, bodies ...string) {
+		id += 1
+		req := &Request{ID: id, Method: method, Line: []byte(`{"method":"` + method + `"}`)}
+		// Responses arrive in reverse order
+		for i := len(bodies) - 1; i >= 0; i-- {
+			r.handle(Response{client: clients[i], Request: req, ID: id, Body: []byte(bodies[i])})
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		send("eth_getBlockByNumber",
+			`{"result":{"transactions":[{"v":"0x1"},{"v":"0x2"}]}}`,
+			`{"result":{"transactions":[{"v":"0x1"},{"v":"0x3"}]}}`,
+			`{"result":{"transactions":[{"v":"0x1"},{"v":"0x2"}]}}`,
+		)
+	}
+	send("eth_getBlockByNumber",
+		`{"result":{"transactions":[{"v":"0x4"},{"v":"0x2"}]}}`,
+		`{"result":{"transactions":[{"v":"0x1"},{"v":"0x2"}]}}`,
+		`{"result":{"transactions":[{"v":"0x1"},{"v":"0x2"}]}}`,
+	)
+	send("eth_chainId", `{"result":"0x1"}`, `{"result":"0x1"}`, `{"result":"0x2","extra":1}`)
+	send("eth_chainId", `{"result":"0x1"}`, `{"result":"0x1"}`, `{"result":"0x1"}`)
+
+	groups := r.groups.Sorted()
+	if len(groups) != 3 {
+		t.Fatalf("got %d groups; want 3: %+v", len(groups), groups)
+	}
+
+	tests := []struct {
+		method    string
+		endpoints string
+		paths     string
+		count     int
+	}{
+		{"eth_getBlockByNumber", "0, 2 != 1", "/result/transactions/*/v", 3},
+		{"eth_chainId", "0, 1 != 2", "/result", 1},
+		{"eth_getBlockByNumber", "0 != 1, 2", "/result/transactions/*/v", 1},
+	}
+	for i, tc := range tests {
+		group := groups[i]
+		if group.Method != tc.method || group.Endpoints() != tc.endpoints || strings.Join(group.Paths, " ") != tc.paths || group.Count != tc.count {
+			t.Errorf("group %d: got: %s %q %q %d; want: %s %q %q %d", i,
+				group.Method, group.Endpoints(), group.Paths, group.Count,
+				tc.method, tc.endpoints, tc.paths, tc.count)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := r.Render(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"1. 3× eth_getBlockByNumber, endpoints 0, 2 != 1: /result/transactions/*/v",
+		`Example: {"method":"eth_getBlockByNumber"}`,
+		`~ /result/transactions/1/v: "0x2" != "0x3"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %q in report:\n%s", want, buf.String())
+		}
+	}
+
+	if got := r.JSON().MismatchGroups; len(got) != 3 || got[0].Count != 3 || len(got[0].Endpoints) != 2 {
+		t.Errorf("unexpected JSON groups: %+v", got)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -21,15 +23,72 @@ type clientStats struct {
 	timeErrors time.Duration // Duration of error responses specifically
 	errors     map[string]int
 
+	timing  histogram
+	methods map[string]*methodStats
+
+	numDiverged int // Mismatches attributed to this endpoint by the reference
+
+	phases [numPhases]histogram // Timing of each phase of HTTP requests, see trace.go
+
+	// Baseline latency probed before the run, see baseline.go
+	baseline         *baseline
+	subtractBaseline bool
+	adjusted         histogram // Timing with the baseline subtracted
+
+	numWarmup       int // Warm-up requests, which are excluded from the stats
+	numWarmupErrors int
+
+	// Head block lag behind the other endpoints, see headlag.go
+	headLag       histogram
+	lastHeadLag   uint64
+	numSyncing    int
+	numHeadErrors int
+
+	// Subscription notifications are counted instead of requests, see subscribe.go
+	Subscription  bool
+	numLate       int // Notifications delivered after a newer one arrived elsewhere
+	numDuplicates int // Notifications delivered more than once
+	numMissing    int // Notifications delivered by other endpoints only
+}
+
+// methodStats are the stats of a single JSON-RPC method.
+type methodStats struct {
+	numTotal      int
+	numErrors     int
+	numMismatched int
+
 	timing histogram
 }
 
-func (stats *clientStats) Count(err error, elapsed time.Duration) {
+// method returns the stats for the method, must be called with the lock held.
+func (stats *clientStats) method(method string) *methodStats {
+	if stats.methods == nil {
+		stats.methods = map[string]*methodStats{}
+	}
+	m, ok := stats.methods[method]
+	if !ok {
+		m = &methodStats{}
+		m.timing.Precision = stats.timing.Precision
+		stats.methods[method] = m
+	}
+	return m
+}
+
+func (stats *clientStats) Count(method string, err error, elapsed time.Duration) {
 	stats.mu.Lock()
 	defer stats.mu.Unlock()
 
 	stats.numTotal += 1
 	stats.timing.Add(elapsed.Seconds())
+	stats.countAdjusted(elapsed)
+
+	m := stats.method(method)
+	m.numTotal += 1
+	m.timing.Add(elapsed.Seconds())
+	if err != nil {
+		m.numErrors += 1
+	}
+
 	if err != nil {
 		stats.numErrors += 1
 		stats.timeErrors += elapsed
@@ -43,7 +102,9 @@ func (stats *clientStats) Count(err error, elapsed time.Duration) {
 
 func (stats *clientStats) Render(w io.Writer) error {
 	// TODO: Use templating?
-	// TODO: Support JSON
+	if stats.Subscription {
+		return stats.renderNotifications(w)
+	}
 	if stats.numTotal == 0 {
 		fmt.Fprintf(w, "   No requests.")
 	}
@@ -68,12 +129,11 @@ func (stats *clientStats) Render(w io.Writer) error {
 	fmt.Fprintf(w, "   Timing:     %0.4fs avg, %0.4fs min, %0.4fs max\n", stats.timing.Average(), stats.timing.Min(), stats.timing.Max())
 	fmt.Fprintf(w, "               %0.4fs standard deviation\n", stddev)
 
-	fmt.Fprintf(w, "\n   Percentiles:\n")
-	buckets := []int{25, 50, 75, 90, 95, 99}
-	percentiles := stats.timing.Percentiles(buckets...)
-	for i, bucket := range buckets {
-		fmt.Fprintf(w, "     %d%% in %0.4fs\n", bucket, percentiles[i])
-	}
+	renderPercentiles(w, &stats.timing)
+
+	stats.renderBaseline(w)
+
+	stats.renderPhases(w)
 
 	fmt.Fprintf(w, "\n   Errors: %0.2f%%\n", errRate)
 
@@ -81,9 +141,106 @@ func (stats *clientStats) Render(w io.Writer) error {
 		fmt.Fprintf(w, "     %d × %q\n", num, msg)
 	}
 
+	if stats.numWarmup > 0 {
+		fmt.Fprintf(w, "\n   Warm-up:    %d requests with %d errors, excluded from the stats\n", stats.numWarmup, stats.numWarmupErrors)
+	}
+
+	if stats.numDiverged > 0 {
+		fmt.Fprintf(w, "\n   Diverged:   %d responses differed from the reference\n", stats.numDiverged)
+	}
+
+	stats.renderHeadLag(w)
+
+	stats.renderMethods(w)
+
 	return nil
 }
 
+// CountWarmup counts a warm-up request, separately from the stats.
+func (stats *clientStats) CountWarmup(err error) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.numWarmup += 1
+	if err != nil {
+		stats.numWarmupErrors += 1
+	}
+}
+
+// CountMismatch counts a mismatched response to a request with the method.
+func (stats *clientStats) CountMismatch(method string) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.method(method).numMismatched += 1
+}
+
+// CountDivergence counts a response which differed from the reference.
+func (stats *clientStats) CountDivergence() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.numDiverged += 1
+}
+
+// methodName returns the name to report for a method.
+func methodName(method string) string {
+	if method == "" {
+		return "(unknown)"
+	}
+	return method
+}
+
+// sortedMethods returns the methods by descending number of requests.
+func (stats *clientStats) sortedMethods() []string {
+	methods := make([]string, 0, len(stats.methods))
+	for method := range stats.methods {
+		methods = append(methods, method)
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		a, b := stats.methods[methods[i]], stats.methods[methods[j]]
+		if a.numTotal != b.numTotal {
+			return a.numTotal > b.numTotal
+		}
+		return methods[i] < methods[j]
+	})
+	return methods
+}
+
+func (stats *clientStats) renderMethods(w io.Writer) {
+	if _, unknown := stats.methods[""]; len(stats.methods) == 0 || (unknown && len(stats.methods) == 1) {
+		// Not JSON-RPC
+		return
+	}
+
+	methods := stats.sortedMethods()
+	width := len("Methods:")
+	for _, method := range methods {
+		if len(methodName(method)) > width {
+			width = len(methodName(method))
+		}
+	}
+
+	fmt.Fprintf(w, "\n   %-*s  %8s  %7s  %10s  %8s  %8s  %8s  %8s\n", width, "Methods:", "Requests", "Errors", "Mismatched", "Avg", "p50", "p95", "p99")
+	for _, method := range methods {
+		m := stats.methods[method]
+		percentiles := m.timing.Percentiles(50, 95, 99)
+		errRate := float64(m.numErrors*100) / float64(m.numTotal)
+		fmt.Fprintf(w, "     %-*s%8d  %6.2f%%  %10d  %7.4fs  %7.4fs  %7.4fs  %7.4fs\n", width, methodName(method), m.numTotal, errRate, m.numMismatched, m.timing.Average(), percentiles[0], percentiles[1], percentiles[2])
+	}
+}
+
+// percentileBuckets are the timing percentiles included in reports.
+var percentileBuckets = []float64{25, 50, 75, 90, 95, 99, 99.9, 99.99}
+
+func renderPercentiles(w io.Writer, h *histogram) {
+	fmt.Fprintf(w, "\n   Percentiles:\n")
+	percentiles := h.Percentiles(percentileBuckets...)
+	for i, bucket := range percentileBuckets {
+		fmt.Fprintf(w, "     %g%% in %0.4fs\n", bucket, percentiles[i])
+	}
+}
+
 func NewClient(endpoint string, concurrency int) (*Client, error) {
 	c := Client{
 		Endpoint:    endpoint,
@@ -108,9 +265,11 @@ func NewClients(endpoints []string, concurrency int, timeout time.Duration) (Cli
 }
 
 type Client struct {
+	Name        string // Optional name of the endpoint, for reporting
 	Endpoint    string
 	Concurrency int           // Number of goroutines to make requests with. Must be >=1.
 	Timeout     time.Duration // Timeout of each request
+	Header      http.Header   // Additional headers to send with each request
 
 	In    chan Request
 	Stats clientStats
@@ -130,12 +289,22 @@ func (client *Client) Serve(ctx context.Context, out chan<- Response) error {
 
 	logger.Debug().Str("endpoint", client.Endpoint).Int("concurrency", client.Concurrency).Msg("starting client")
 
+	// Each goroutine gets its own transport, unless it can be shared
+	first, err := NewTransport(client.Endpoint, client.Timeout, client.Header)
+	if err != nil {
+		return err
+	}
+
 	for i := 0; i < client.Concurrency; i++ {
+		i := i
 		g.Go(func() error {
 			// Consume requests
-			t, err := NewTransport(client.Endpoint, client.Timeout)
-			if err != nil {
-				return err
+			t := first
+			if i > 0 && !isShared(first) {
+				var err error
+				if t, err = NewTransport(client.Endpoint, client.Timeout, client.Header); err != nil {
+					return err
+				}
 			}
 			for {
 				select {
@@ -149,7 +318,14 @@ func (client *Client) Serve(ctx context.Context, out chan<- Response) error {
 						return nil
 					}
 					resp := req.Do(t)
-					client.Stats.Count(resp.Err, resp.Elapsed)
+					if req.Warmup {
+						client.Stats.CountWarmup(resp.Err)
+					} else {
+						client.Stats.Count(req.Method, resp.Err, resp.Elapsed)
+						if resp.Phases != nil {
+							client.Stats.CountPhases(*resp.Phases)
+						}
+					}
 					select {
 					case out <- resp:
 					default:
@@ -182,16 +358,33 @@ func (c Clients) Finalize() {
 }
 
 func (c Clients) Send(ctx context.Context, line []byte) error {
+	return c.SendRequest(ctx, Request{Line: line, Timestamp: time.Now()})
+}
+
+// SendScheduled sends a request which was scheduled to be sent at a time, and
+// is timed from then.
+func (c Clients) SendScheduled(ctx context.Context, line []byte, at time.Time, stage int) error {
+	return c.SendRequest(ctx, Request{Line: line, Timestamp: at, Scheduled: true, Stage: stage})
+}
+
+// Backlog replaces the request queue of each client with one that holds n
+// requests. Must be called before serving.
+func (c Clients) Backlog(n int) {
+	for _, client := range c {
+		client.In = make(chan Request, n)
+	}
+}
+
+// SendRequest sends a copy of the request to every client, with the next
+// request ID.
+func (c Clients) SendRequest(ctx context.Context, req Request) error {
 	id += 1
+	req.ID = id
+	req.Method = jsonrpcMethod(req.Line)
 	for _, client := range c {
+		req.client = client
 		select {
-		case client.In <- Request{
-			client: client,
-			ID:     id,
-
-			Line:      line,
-			Timestamp: time.Now(),
-		}:
+		case client.In <- req:
 		case <-ctx.Done():
 			return ctx.Err()
 		}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// defaultComparator is used when no comparator is configured.
+var defaultComparator = &comparator{}
+
+// comparator decides whether responses are equivalent. JSON-RPC responses are
+// compared by their result or error, ignoring the envelope: the id and
+// jsonrpc version can differ, batch responses can be in any order, and errors
+// only need the same code.
+type comparator struct {
+	// CompareErrorMessages requires JSON-RPC errors to have the same message
+	// and data, not just the same code.
+	CompareErrorMessages bool
+
+	// Ignore removes paths from responses before they're compared.
+	Ignore []ignoreRule
+
+	// Normalize rewrites equivalent values to the same format before they're
+	// compared.
+	Normalize normalizer
+}
+
+// Equal returns true if both responses have equivalent bodies, or the same
+// transport errors.
+func (c *comparator) Equal(a, b Response) bool {
+	if a.Err == nil && b.Err == nil {
+		var method string
+		if a.Request != nil {
+			method = a.Request.Method
+		}
+		return c.equalBodies(method, a.Body, b.Body)
+	}
+	if a.Err != nil && b.Err != nil {
+		return a.Err.Error() == b.Err.Error() && bytes.Equal(a.Body, b.Body)
+	}
+	return false
+}
+
+// EqualBodies returns true if the bodies are byte-equal, or equivalent JSON.
+// Ignore rules scoped to a method don't apply.
+func (c *comparator) EqualBodies(a, b []byte) bool {
+	return c.equalBodies("", a, b)
+}
+
+func (c *comparator) equalBodies(method string, a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	aVal, err := decodeJSON(a)
+	if err != nil {
+		return false
+	}
+	bVal, err := decodeJSON(b)
+	if err != nil {
+		return false
+	}
+	return c.equalValues(c.prepare(method, aVal), c.prepare(method, bVal))
+}
+
+// prepare applies the ignore rules for the method and the normalizer to a
+// decoded body. Rules apply to each response of a batch.
+func (c *comparator) prepare(method string, v interface{}) interface{} {
+	if len(c.Ignore) > 0 {
+		if batch, ok := v.([]interface{}); ok && isBatch(batch) {
+			for i := range batch {
+				batch[i] = c.ignore(method, batch[i])
+			}
+		} else {
+			v = c.ignore(method, v)
+		}
+	}
+	if c.Normalize.enabled() {
+		v = c.Normalize.Normalize(v)
+	}
+	return v
+}
+
+func (c *comparator) ignore(method string, v interface{}) interface{} {
+	for _, rule := range c.Ignore {
+		if rule.Method != "" && rule.Method != method {
+			continue
+		}
+		v = deletePath(v, rule.Path)
+	}
+	return v
+}
+
+// decodeJSON decodes a body with numbers kept as written, so that large
+// integers aren't rounded.
+func decodeJSON(body []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (c *comparator) equalValues(a, b interface{}) bool {
+	if aBatch, ok := a.([]interface{}); ok {
+		if bBatch, ok := b.([]interface{}); ok && isBatch(aBatch) && isBatch(bBatch) {
+			return c.equalBatches(aBatch, bBatch)
+		}
+	}
+	if aMsg, ok := envelope(a); ok {
+		if bMsg, ok := envelope(b); ok {
+			return c.equalEnvelopes(aMsg, bMsg)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// envelope returns the value as a JSON-RPC response object, if it is one.
+func envelope(v interface{}) (map[string]interface{}, bool) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	_, hasResult := obj["result"]
+	_, hasError := obj["error"]
+	return obj, hasResult || hasError
+}
+
+// isBatch returns true if every element is a JSON-RPC response object.
+func isBatch(batch []interface{}) bool {
+	if len(batch) == 0 {
+		return false
+	}
+	for _, v := range batch {
+		if _, ok := envelope(v); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *comparator) equalEnvelopes(a, b map[string]interface{}) bool {
+	aResult, aHasResult := a["result"]
+	bResult, bHasResult := b["result"]
+	if aHasResult != bHasResult {
+		return false
+	}
+	if aHasResult {
+		return reflect.DeepEqual(aResult, bResult)
+	}
+	return c.equalErrors(a["error"], b["error"])
+}
+
+func (c *comparator) equalErrors(a, b interface{}) bool {
+	aErr, aOK := a.(map[string]interface{})
+	bErr, bOK := b.(map[string]interface{})
+	if !aOK || !bOK {
+		return reflect.DeepEqual(a, b)
+	}
+	if !equalNumbers(aErr["code"], bErr["code"]) {
+		return false
+	}
+	if c.CompareErrorMessages {
+		return reflect.DeepEqual(aErr["message"], bErr["message"]) && reflect.DeepEqual(aErr["data"], bErr["data"])
+	}
+	return true
+}
+
+// equalNumbers compares JSON numbers by value, so that 1 and 1.0 are equal.
+func equalNumbers(a, b interface{}) bool {
+	aNum, aOK := a.(json.Number)
+	bNum, bOK := b.(json.Number)
+	if !aOK || !bOK {
+		return reflect.DeepEqual(a, b)
+	}
+	aFloat, aErr := aNum.Float64()
+	bFloat, bErr := bNum.Float64()
+	if aErr != nil || bErr != nil {
+		return aNum == bNum
+	}
+	return aFloat == bFloat
+}
+
+// equalBatches matches batch responses by their ids, since they can be in
+// any order. Batches without unique ids are compared in order.
+func (c *comparator) equalBatches(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	byID := make(map[string]map[string]interface{}, len(b))
+	for _, v := range b {
+		msg, _ := envelope(v)
+		id, ok := envelopeID(msg)
+		if _, dup := byID[id]; !ok || dup {
+			byID = nil
+			break
+		}
+		byID[id] = msg
+	}
+
+	for i, v := range a {
+		aMsg, _ := envelope(v)
+		bMsg, _ := envelope(b[i])
+		if byID != nil {
+			id, _ := envelopeID(aMsg)
+			var ok bool
+			if bMsg, ok = byID[id]; !ok {
+				return false
+			}
+		}
+		if !c.equalEnvelopes(aMsg, bMsg) {
+			return false
+		}
+	}
+	return true
+}
+
+func envelopeID(msg map[string]interface{}) (string, bool) {
+	id, ok := msg["id"]
+	if !ok || id == nil {
+		return "", false
+	}
+	b, err := json.Marshal(id)
+	return string(b), err == nil
+}
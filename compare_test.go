@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestComparator(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{`{"jsonrpc":"2.0","id":1,"result":"0x1"}`, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`, true},
+		{`{"jsonrpc":"2.0","id":1,"result":"0x1"}`, `{"id":2,"result":"0x1","jsonrpc":"2.0"}`, true},
+		{`{"jsonrpc":"2.0","id":1,"result":"0x1"}`, `{"jsonrpc":"2.0","id":1,"result":"0x2"}`, false},
+		{`{"jsonrpc":"2.0","id":1,"result":{"a":1,"b":[1,2]}}`, `{"jsonrpc":"2.0","id":1,"result":{"b":[1,2],"a":1}}`, true},
+		{`{"jsonrpc":"2.0","id":1,"result":null}`, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"oops"}}`, false},
+		{`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"oops"}}`, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"header not found"}}`, true},
+		{`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"oops"}}`, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"oops"}}`, false},
+		{`[{"id":1,"result":"a"},{"id":2,"result":"b"}]`, `[{"id":2,"result":"b"},{"id":1,"result":"a"}]`, true},
+		{`[{"id":1,"result":"a"},{"id":2,"result":"b"}]`, `[{"id":2,"result":"a"},{"id":1,"result":"b"}]`, false},
+		{`[{"id":1,"result":"a"},{"id":2,"result":"b"}]`, `[{"id":1,"result":"a"}]`, false},
+		{`[1, 2]`, `[1,2]`, true},
+		{`"foo"`, `"foo" `, true},
+		{`{"a":1}`, `{"a":1,"b":2}`, false},
+		{`not json`, `not json`, true},
+		{`not json`, `not json!`, false},
+	}
+
+	c := comparator{}
+	for _, tc := range tests {
+		if got := c.EqualBodies([]byte(tc.a), []byte(tc.b)); got != tc.want {
+			t.Errorf("%s == %s: got: %t; want: %t", tc.a, tc.b, got, tc.want)
+		}
+	}
+
+	c.CompareErrorMessages = true
+	if c.EqualBodies([]byte(`{"id":1,"error":{"code":-32000,"message":"oops"}}`), []byte(`{"id":1,"error":{"code":-32000,"message":"nope"}}`)) {
+		t.Error("error messages should not match")
+	}
+
+	if c.Equal(Response{Err: errors.New("oops")}, Response{}) {
+		t.Error("error should not match success")
+	}
+	if !c.Equal(Response{Err: errors.New("oops")}, Response{Err: errors.New("oops")}) {
+		t.Error("same errors should match")
+	}
+}
+
+func TestComparatorRules(t *testing.T) {
+	rule := func(s string) ignoreRule {
+		r, err := parseIgnoreRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	c := comparator{
+		Ignore: []ignoreRule{
+			rule("result.totalDifficulty"),
+			rule("eth_getBlockByNumber:result.size"),
+		},
+		Normalize: normalizer{HexCase: true, LeadingZeros: true, Quantities: true},
+	}
+
+	tests := []struct {
+		method string
+		a, b   string
+		want   bool
+	}{
+		{"", `{"id":1,"result":{"number":"0x1","totalDifficulty":"0x2"}}`, `{"id":1,"result":{"number":"0x1"}}`, true},
+		{"", `{"id":1,"result":{"number":"0x1"}}`, `{"id":1,"result":{"number":"0x2"}}`, false},
+		{"", `[{"id":1,"result":{"totalDifficulty":"0x2"}}]`, `[{"id":1,"result":{"totalDifficulty":"0x3"}}]`, true},
+		{"eth_getBlockByNumber", `{"id":1,"result":{"size":"0x1"}}`, `{"id":1,"result":{"size":"0x2"}}`, true},
+		{"eth_getBlockByHash", `{"id":1,"result":{"size":"0x1"}}`, `{"id":1,"result":{"size":"0x2"}}`, false},
+		{"", `{"id":1,"result":{"gas":"0x0A"}}`, `{"id":1,"result":{"gas":10}}`, true},
+		{"", `{"id":1,"result":"0xABC"}`, `{"id":1,"result":"0x0abc"}`, true},
+	}
+
+	for _, tc := range tests {
+		a := Response{Request: &Request{Method: tc.method}, Body: []byte(tc.a)}
+		b := Response{Request: &Request{Method: tc.method}, Body: []byte(tc.b)}
+		if got := c.Equal(a, b); got != tc.want {
+			t.Errorf("%s %s == %s: got: %t; want: %t", tc.method, tc.a, tc.b, got, tc.want)
+		}
+	}
+}
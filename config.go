@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v2"
+)
+
+// endpointConfig describes an endpoint with its own settings, which override
+// the run options for that endpoint.
+type endpointConfig struct {
+	Name        string            `yaml:"name"`
+	URL         string            `yaml:"url"`
+	Mode        string            `yaml:"mode"` // Transport mode, such as "get" or "pipeline"
+	Timeout     string            `yaml:"timeout"`
+	Concurrency int               `yaml:"concurrency"`
+	Headers     map[string]string `yaml:"headers"`
+	BasicAuth   string            `yaml:"basic_auth"`
+	Bearer      string            `yaml:"bearer"`
+}
+
+// String returns the endpoint URI, including the mode in the scheme.
+func (e endpointConfig) String() string {
+	if e.Mode == "" {
+		return e.URL
+	}
+	parts := strings.SplitN(e.URL, "://", 2)
+	if len(parts) != 2 {
+		return e.URL
+	}
+	return parts[0] + "+" + e.Mode + "://" + parts[1]
+}
+
+// config is the format of the --config file. Options are keyed by their long
+// flag name, like "stop-after".
+type config struct {
+	Options   map[string]interface{} `yaml:"options"`
+	Endpoints []endpointConfig       `yaml:"endpoints"`
+}
+
+// loadConfig reads a YAML (or JSON) config file into options. Options that
+// were set on the command line take precedence over the file, and endpoints
+// given as arguments replace the endpoints in the file.
+func loadConfig(parser *flags.Parser, options *Options, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	var cfg config
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return fmt.Errorf("failed to parse config %q: %w", path, err)
+	}
+
+	if err := applyOptions(parser, options, cfg.Options); err != nil {
+		return fmt.Errorf("invalid config %q: %w", path, err)
+	}
+
+	for i, e := range cfg.Endpoints {
+		if e.URL == "" {
+			return fmt.Errorf("invalid config %q: endpoint %d is missing a url", path, i)
+		}
+	}
+	if len(options.Args.Endpoints) == 0 {
+		options.Endpoints = cfg.Endpoints
+	}
+	return nil
+}
+
+// applyOptions sets the option fields with matching long flag names, unless
+// they were already set on the command line.
+func applyOptions(parser *flags.Parser, options *Options, values map[string]interface{}) error {
+	v := reflect.ValueOf(options).Elem()
+	fields := map[string]reflect.Value{}
+	for i := 0; i < v.NumField(); i++ {
+		if long := v.Type().Field(i).Tag.Get("long"); long != "" {
+			fields[long] = v.Field(i)
+		}
+	}
+
+	for name, value := range values {
+		field, ok := fields[name]
+		if !ok || name == "config" {
+			return fmt.Errorf("unknown option: %s", name)
+		}
+		if opt := parser.FindOptionByLongName(name); opt != nil && opt.IsSet() && !opt.IsSetDefault() {
+			continue
+		}
+
+		// Round-trip through YAML to convert the value into the field's type
+		b, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		out := reflect.New(field.Type())
+		if err := yaml.Unmarshal(b, out.Interface()); err != nil {
+			return fmt.Errorf("invalid value for option %s: %w", name, err)
+		}
+		field.Set(out.Elem())
+	}
+	return nil
+}
+
+// newEndpointClients creates a client for each endpoint, using the given
+// concurrency and timeout unless the endpoint overrides them.
+func newEndpointClients(endpoints []endpointConfig, concurrency int, timeout time.Duration) (Clients, error) {
+	clients := make(Clients, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		n := concurrency
+		if endpoint.Concurrency > 0 {
+			n = endpoint.Concurrency
+		}
+		c, err := NewClient(endpoint.String(), n)
+		if err != nil {
+			return nil, err
+		}
+		c.Name = endpoint.Name
+		c.Timeout = timeout
+		if endpoint.Timeout != "" {
+			d, err := time.ParseDuration(endpoint.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse timeout for endpoint %q: %w", endpoint.URL, err)
+			}
+			c.Timeout = d
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+// endpointHeaderOptions adds the headers of each endpoint to the header
+// options, scoped to that endpoint.
+func endpointHeaderOptions(endpoints []endpointConfig, options headerOptions) headerOptions {
+	for i, endpoint := range endpoints {
+		names := make([]string, 0, len(endpoint.Headers))
+		for name := range endpoint.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			options.Headers = append(options.Headers, fmt.Sprintf("%d=%s: %s", i, name, endpoint.Headers[name]))
+		}
+		if endpoint.BasicAuth != "" {
+			options.BasicAuth = append(options.BasicAuth, fmt.Sprintf("%d=%s", i, endpoint.BasicAuth))
+		}
+		if endpoint.Bearer != "" {
+			options.Bearer = append(options.Bearer, fmt.Sprintf("%d=%s", i, endpoint.Bearer))
+		}
+	}
+	return options
+}
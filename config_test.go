@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "versus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "versus.yaml")
+	err = ioutil.WriteFile(path, []byte(`
+options:
+  timeout: 5s
+  stop-after: 100
+  concurrency: 2
+  header: ["X-Foo: bar"]
+endpoints:
+  - name: local
+    url: http://localhost:8545/
+    concurrency: 8
+    timeout: 1s
+  - url: wss://example.com/
+    mode: pipeline
+    bearer: sekret
+    headers:
+      X-Project: foo
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := Options{}
+	parser := flags.NewParser(&options, flags.Default)
+	if _, err := parser.ParseArgs([]string{"--concurrency=3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(parser, &options, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := options.Timeout, "5s"; got != want {
+		t.Errorf("timeout got: %q; want: %q", got, want)
+	}
+	if got, want := options.StopAfter, "100"; got != want {
+		t.Errorf("stop-after got: %q; want: %q", got, want)
+	}
+	if got, want := options.Concurrency, 3; got != want {
+		t.Errorf("concurrency got: %d; want: %d", got, want)
+	}
+	if got, want := options.Headers, []string{"X-Foo: bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("headers got: %q; want: %q", got, want)
+	}
+
+	clients, err := newEndpointClients(options.Endpoints, options.Concurrency, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := clients[0].Name, "local"; got != want {
+		t.Errorf("name got: %q; want: %q", got, want)
+	}
+	if got, want := clients[0].Concurrency, 8; got != want {
+		t.Errorf("concurrency got: %d; want: %d", got, want)
+	}
+	if got, want := clients[0].Timeout, time.Second; got != want {
+		t.Errorf("timeout got: %s; want: %s", got, want)
+	}
+	if got, want := clients[1].Endpoint, "wss+pipeline://example.com/"; got != want {
+		t.Errorf("endpoint got: %q; want: %q", got, want)
+	}
+	if got, want := clients[1].Concurrency, 3; got != want {
+		t.Errorf("concurrency got: %d; want: %d", got, want)
+	}
+
+	headers, err := endpointHeaders(len(clients), endpointHeaderOptions(options.Endpoints, headerOptions{Headers: options.Headers}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := headers[1].Get("Authorization"), "Bearer sekret"; got != want {
+		t.Errorf("authorization got: %q; want: %q", got, want)
+	}
+	if got, want := headers[1].Get("X-Project"), "foo"; got != want {
+		t.Errorf("project header got: %q; want: %q", got, want)
+	}
+	if got := headers[0].Get("X-Project"); got != "" {
+		t.Errorf("unexpected project header on other endpoint: %q", got)
+	}
+
+	// Endpoint arguments replace the config endpoints
+	options = Options{}
+	parser = flags.NewParser(&options, flags.Default)
+	if _, err := parser.ParseArgs([]string{"noop://"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(parser, &options, path); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(options.Endpoints), 0; got != want {
+		t.Errorf("endpoints got: %d; want: %d", got, want)
+	}
+	if got, want := options.Concurrency, 2; got != want {
+		t.Errorf("concurrency got: %d; want: %d", got, want)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type diffOp byte
+
+const (
+	diffAdded   diffOp = '+'
+	diffRemoved diffOp = '-'
+	diffChanged diffOp = '~'
+)
+
+// diffEntry is a difference between two JSON values, at a JSON pointer path
+// (RFC 6901) like "/result/transactions/3/v".
+type diffEntry struct {
+	Op   diffOp
+	Path string
+	A    interface{} // Value in the first document, unless added
+	B    interface{} // Value in the second document, unless removed
+}
+
+// diffValues returns the differences between two decoded JSON values, with
+// object keys in sorted order and arrays compared by index.
+func diffValues(a, b interface{}) []diffEntry {
+	var entries []diffEntry
+	diffWalk(&entries, "", a, b)
+	return entries
+}
+
+func diffWalk(entries *[]diffEntry, path string, a, b interface{}) {
+	switch aVal := a.(type) {
+	case map[string]interface{}:
+		bVal, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(aVal)+len(bVal))
+		for key := range aVal {
+			keys = append(keys, key)
+		}
+		for key := range bVal {
+			if _, ok := aVal[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyPath := path + "/" + escapePointer(key)
+			aElem, aOK := aVal[key]
+			bElem, bOK := bVal[key]
+			switch {
+			case !bOK:
+				*entries = append(*entries, diffEntry{Op: diffRemoved, Path: keyPath, A: aElem})
+			case !aOK:
+				*entries = append(*entries, diffEntry{Op: diffAdded, Path: keyPath, B: bElem})
+			default:
+				diffWalk(entries, keyPath, aElem, bElem)
+			}
+		}
+		return
+	case []interface{}:
+		bVal, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(aVal) || i < len(bVal); i++ {
+			indexPath := path + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(bVal):
+				*entries = append(*entries, diffEntry{Op: diffRemoved, Path: indexPath, A: aVal[i]})
+			case i >= len(aVal):
+				*entries = append(*entries, diffEntry{Op: diffAdded, Path: indexPath, B: bVal[i]})
+			default:
+				diffWalk(entries, indexPath, aVal[i], bVal[i])
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*entries = append(*entries, diffEntry{Op: diffChanged, Path: path, A: a, B: b})
+	}
+}
+
+func escapePointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+// Diff returns the differences between two response bodies after the ignore
+// rules and normalizers are applied. Only the result or error of envelopes
+// are compared, and batches are sorted by id. Returns false if either body is
+// not JSON.
+func (c *comparator) Diff(method string, a, b []byte) ([]diffEntry, bool) {
+	aVal, err := decodeJSON(a)
+	if err != nil {
+		return nil, false
+	}
+	bVal, err := decodeJSON(b)
+	if err != nil {
+		return nil, false
+	}
+	return diffValues(diffable(c.prepare(method, aVal)), diffable(c.prepare(method, bVal))), true
+}
+
+// diffable strips the envelope fields that aren't compared from a response,
+// leaving the result or error.
+func diffable(v interface{}) interface{} {
+	if batch, ok := v.([]interface{}); ok && isBatch(batch) {
+		sort.SliceStable(batch, func(i, j int) bool {
+			iMsg, _ := envelope(batch[i])
+			jMsg, _ := envelope(batch[j])
+			iID, _ := envelopeID(iMsg)
+			jID, _ := envelopeID(jMsg)
+			return iID < jID
+		})
+		return batch
+	}
+	if msg, ok := envelope(v); ok {
+		for key := range msg {
+			if key != "result" && key != "error" {
+				delete(msg, key)
+			}
+		}
+	}
+	return v
+}
+
+const (
+	defaultDiffChanges = 20
+	defaultDiffValue   = 120
+
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// diffFormat writes differences as one line per path.
+type diffFormat struct {
+	Color      bool // Colorize with terminal escape codes
+	Raw        bool // Write full bodies instead of differences
+	MaxChanges int  // Maximum number of differences to write, defaults to 20
+	MaxValue   int  // Maximum bytes of each value to write, defaults to 120
+}
+
+// parseDiffFormat parses the name of a diff format, where auto is colorized
+// if color is true.
+func parseDiffFormat(name string, color bool) (diffFormat, error) {
+	switch name {
+	case "auto":
+		return diffFormat{Color: color}, nil
+	case "color":
+		return diffFormat{Color: true}, nil
+	case "plain":
+		return diffFormat{}, nil
+	case "raw":
+		return diffFormat{Raw: true}, nil
+	}
+	return diffFormat{}, fmt.Errorf("invalid diff format: %s", name)
+}
+
+// Write writes the differences between two bodies. Bodies that aren't JSON
+// are written in full, truncated unless the format is raw.
+func (f diffFormat) Write(w io.Writer, c *comparator, method string, a, b []byte) {
+	if !f.Raw {
+		if entries, ok := c.Diff(method, a, b); ok {
+			f.writeEntries(w, entries)
+			return
+		}
+	}
+	fmt.Fprintf(w, "\t%s %s\n", f.color(colorRed, "-"), f.truncate(string(a)))
+	fmt.Fprintf(w, "\t%s %s\n", f.color(colorGreen, "+"), f.truncate(string(b)))
+}
+
+func (f diffFormat) writeEntries(w io.Writer, entries []diffEntry) {
+	maxChanges := f.MaxChanges
+	if maxChanges <= 0 {
+		maxChanges = defaultDiffChanges
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(w, "\t(no structural differences)\n")
+	}
+	for i, entry := range entries {
+		if i == maxChanges {
+			fmt.Fprintf(w, "\t... and %d more differences\n", len(entries)-i)
+			break
+		}
+		path := entry.Path
+		if path == "" {
+			path = "/"
+		}
+		switch entry.Op {
+		case diffAdded:
+			fmt.Fprintf(w, "\t%s %s: %s\n", f.color(colorGreen, "+"), path, f.value(entry.B))
+		case diffRemoved:
+			fmt.Fprintf(w, "\t%s %s: %s\n", f.color(colorRed, "-"), path, f.value(entry.A))
+		default:
+			fmt.Fprintf(w, "\t%s %s: %s != %s\n", f.color(colorYellow, "~"), path, f.color(colorRed, f.value(entry.A)), f.color(colorGreen, f.value(entry.B)))
+		}
+	}
+}
+
+func (f diffFormat) value(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return f.truncate(string(b))
+}
+
+func (f diffFormat) truncate(s string) string {
+	if f.Raw {
+		return s
+	}
+	maxValue := f.MaxValue
+	if maxValue <= 0 {
+		maxValue = defaultDiffValue
+	}
+	if len(s) <= maxValue {
+		return s
+	}
+	return fmt.Sprintf("%s... (%d bytes)", s[:maxValue], len(s))
+}
+
+func (f diffFormat) color(code, s string) string {
+	if !f.Color {
+		return s
+	}
+	return code + s + colorReset
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiffValues(t *testing.T) {
+	a, _ := decodeJSON([]byte(`{"a":1,"b":{"c":[1,2,3],"d/e":"x"},"f":"gone"}`))
+	b, _ := decodeJSON([]byte(`{"a":2,"b":{"c":[1,5],"d/e":"x"},"g":"new"}`))
+
+	got := diffValues(a, b)
+	want := []diffEntry{
+		{Op: diffChanged, Path: "/a"},
+		{Op: diffChanged, Path: "/b/c/1"},
+		{Op: diffRemoved, Path: "/b/c/2"},
+		{Op: diffRemoved, Path: "/f"},
+		{Op: diffAdded, Path: "/g"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got: %+v; want: %+v", got, want)
+	}
+	for i := range got {
+		if got[i].Op != want[i].Op || got[i].Path != want[i].Path {
+			t.Errorf("%d: got: %c %s; want: %c %s", i, got[i].Op, got[i].Path, want[i].Op, want[i].Path)
+		}
+	}
+
+	if got := escapePointer("a/b~c"); got != "a~1b~0c" {
+		t.Errorf("got: %s; want: a~1b~0c", got)
+	}
+}
+
+func TestComparatorDiff(t *testing.T) {
+	c := comparator{}
+	entries, ok := c.Diff("", []byte(`{"jsonrpc":"2.0","id":1,"result":{"n":"0x1"}}`), []byte(`{"id":2,"result":{"n":"0x2"}}`))
+	if !ok {
+		t.Fatal("failed to diff")
+	}
+	if len(entries) != 1 || entries[0].Path != "/result/n" {
+		t.Errorf("got: %+v; want a single change to /result/n", entries)
+	}
+
+	if _, ok := c.Diff("", []byte(`not json`), []byte(`{}`)); ok {
+		t.Error("diffed a body that is not JSON")
+	}
+}
+
+func TestResponsesDiff(t *testing.T) {
+	clientA := &Client{Endpoint: "http://a"}
+	clientB := &Client{Endpoint: "http://b", Name: "b"}
+	resps := Responses{
+		{client: clientA, Body: []byte(`{"id":1,"result":{"big":"` + strings.Repeat("x", 200) + `","n":1}}`)},
+		{client: clientB, Body: []byte(`{"id":1,"result":{"big":"y","n":1,"extra":true}}`)},
+	}
+
+	got := resps.Diff(defaultComparator, diffFormat{MaxValue: 10})
+	for _, want := range []string{"http://a", "!= b", "~ /result/big: \"xxxxxxxxx... (202 bytes) != \"y\"", "+ /result/extra: true"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+
+	got = resps.Diff(defaultComparator, diffFormat{Raw: true})
+	if !strings.Contains(got, strings.Repeat("x", 200)) {
+		t.Errorf("raw diff is missing the full body:\n%s", got)
+	}
+
+	got = resps.Diff(defaultComparator, diffFormat{Color: true})
+	if !strings.Contains(got, colorGreen+"+"+colorReset) {
+		t.Errorf("diff is not colorized:\n%s", got)
+	}
+
+	resps[1].Err = errors.New("timeout")
+	if got := resps.Diff(defaultComparator, diffFormat{}); !strings.Contains(got, "error mismatch: <nil> != timeout") {
+		t.Errorf("missing error mismatch in:\n%s", got)
+	}
+
+	if _, err := parseDiffFormat("fancy", false); err == nil {
+		t.Error("expected error for invalid diff format")
+	}
+}
@@ -7,4 +7,5 @@ require (
 	github.com/jessevdk/go-flags v1.4.0
 	github.com/rs/zerolog v1.17.2
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// splitEndpointIndex splits an optional "N=" endpoint index prefix from an
+// option value. The index is -1 if the value applies to all endpoints.
+func splitEndpointIndex(s string) (int, string) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return -1, s
+	}
+	n, err := strconv.ParseUint(s[:i], 10, 32)
+	if err != nil {
+		return -1, s
+	}
+	return int(n), s[i+1:]
+}
+
+// isSecret returns true if the value refers to a secret for resolveSecret.
+func isSecret(s string) bool {
+	return strings.HasPrefix(s, "env:") || strings.HasPrefix(s, "file:")
+}
+
+// resolveSecret reads values prefixed with "env:" from the named environment
+// variable, and values prefixed with "file:" from the named file, so that
+// secrets don't need to be passed on the command line.
+func resolveSecret(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, "env:"):
+		name := strings.TrimPrefix(s, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable is not set: %s", name)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "file:"):
+		b, err := ioutil.ReadFile(strings.TrimPrefix(s, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return s, nil
+}
+
+// headerOptions are the options that produce request headers, each value can
+// be prefixed with "N=" to apply to a single endpoint.
+type headerOptions struct {
+	Headers   []string // "Name: value"
+	BasicAuth []string // "user:password"
+	Bearer    []string // "token"
+}
+
+// endpointHeaders returns the headers to send to each of n endpoints. Options
+// for a single endpoint replace the values of the same header from options for
+// every endpoint, so that an endpoint can have its own credentials.
+func endpointHeaders(n int, options headerOptions) ([]http.Header, error) {
+	global := http.Header{}
+	endpoint := make([]http.Header, n)
+	for i := range endpoint {
+		endpoint[i] = http.Header{}
+	}
+
+	set := func(opt string, s string, fn func(string) (string, string, error)) error {
+		idx, value := splitEndpointIndex(s)
+		if idx >= n {
+			return fmt.Errorf("%s refers to endpoint %d, but there are only %d endpoints", opt, idx, n)
+		}
+		key, value, err := fn(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", opt, err)
+		}
+		if idx == -1 {
+			global.Add(key, value)
+		} else {
+			endpoint[idx].Add(key, value)
+		}
+		return nil
+	}
+
+	for _, s := range options.Headers {
+		err := set("header", s, func(s string) (string, string, error) {
+			parts := strings.SplitN(s, ":", 2)
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+				return "", "", fmt.Errorf("must be \"Name: value\": %q", s)
+			}
+			value, err := resolveSecret(strings.TrimSpace(parts[1]))
+			return strings.TrimSpace(parts[0]), value, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range options.BasicAuth {
+		err := set("basic auth", s, func(s string) (string, string, error) {
+			// Either the credentials or only the password can be a secret,
+			// which is resolved once
+			creds, err := resolveSecret(s)
+			if err != nil {
+				return "", "", err
+			}
+			parts := strings.SplitN(creds, ":", 2)
+			if len(parts) != 2 {
+				return "", "", fmt.Errorf("must be \"user:password\"")
+			}
+			password := parts[1]
+			if !isSecret(s) {
+				password, err = resolveSecret(password)
+				if err != nil {
+					return "", "", err
+				}
+			}
+			encoded := base64.StdEncoding.EncodeToString([]byte(parts[0] + ":" + password))
+			return "Authorization", "Basic " + encoded, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range options.Bearer {
+		err := set("bearer token", s, func(s string) (string, string, error) {
+			token, err := resolveSecret(s)
+			return "Authorization", "Bearer " + token, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	headers := make([]http.Header, n)
+	for i := range headers {
+		headers[i] = global.Clone()
+		for key, values := range endpoint[i] {
+			headers[i][key] = values
+		}
+	}
+	return headers, nil
+}
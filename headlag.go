@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// syncing queries whether an endpoint is still syncing.
+func syncing(t Transport) (bool, error) {
+	body, err := t.Send([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_syncing","params":[]}`))
+	if err != nil {
+		return false, err
+	}
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false, fmt.Errorf("invalid eth_syncing response: %w", err)
+	}
+	// The result is false, or an object with the sync progress
+	return len(resp.Result) > 0 && string(resp.Result) != "false", nil
+}
+
+// headMonitor polls the head block of every endpoint during a run, and counts
+// how far each endpoint lags behind the highest head.
+type headMonitor struct {
+	clients    Clients
+	transports []Transport
+	interval   time.Duration
+}
+
+func newHeadMonitor(clients Clients, interval time.Duration) (*headMonitor, error) {
+	m := &headMonitor{
+		clients:  clients,
+		interval: interval,
+	}
+	for _, c := range clients {
+		t, err := NewTransport(c.Endpoint, c.Timeout, c.Header)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.transports = append(m.transports, t)
+	}
+	return m, nil
+}
+
+// Close closes the transports used to poll the endpoints, must not be called
+// while Run is polling.
+func (m *headMonitor) Close() {
+	for _, t := range m.transports {
+		closeTransport(t)
+	}
+}
+
+// Run polls the endpoints immediately and on every interval, until ctx is done.
+func (m *headMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.Poll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll queries the head of every endpoint at once, and counts their lag.
+func (m *headMonitor) Poll() {
+	heads := make([]uint64, len(m.transports))
+	errs := make([]error, len(m.transports))
+	var wg sync.WaitGroup
+	for i, t := range m.transports {
+		i, t := i, t
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			heads[i], errs[i] = blockNumber(t)
+			if errs[i] != nil {
+				return
+			}
+			isSyncing, err := syncing(t)
+			if err != nil {
+				// Not every endpoint supports eth_syncing
+				logger.Debug().Err(err).Str("endpoint", m.clients[i].Endpoint).Msg("failed to get sync status")
+			}
+			if isSyncing {
+				m.clients[i].Stats.CountSyncing()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var highest uint64
+	for i, head := range heads {
+		if errs[i] == nil && head > highest {
+			highest = head
+		}
+	}
+	for i, c := range m.clients {
+		if errs[i] != nil {
+			logger.Debug().Err(errs[i]).Str("endpoint", c.Endpoint).Msg("failed to get head block")
+			c.Stats.CountHeadError()
+			continue
+		}
+		c.Stats.CountHeadLag(highest - heads[i])
+	}
+}
+
+// CountHeadLag counts a sample of how many blocks the endpoint's head is
+// behind the highest head of all endpoints.
+func (stats *clientStats) CountHeadLag(lag uint64) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.headLag.Add(float64(lag))
+	stats.lastHeadLag = lag
+}
+
+// CountSyncing counts a sample where the endpoint reported that it's syncing.
+func (stats *clientStats) CountSyncing() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.numSyncing += 1
+}
+
+// CountHeadError counts a failed sample of the endpoint's head.
+func (stats *clientStats) CountHeadError() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.numHeadErrors += 1
+}
+
+func (stats *clientStats) renderHeadLag(w io.Writer) {
+	if stats.headLag.Len() == 0 && stats.numHeadErrors == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n   Head lag:   %d samples, %d syncing, %d errors\n", stats.headLag.Len(), stats.numSyncing, stats.numHeadErrors)
+	if stats.headLag.Len() == 0 {
+		return
+	}
+	percentiles := stats.headLag.Percentiles(50, 95, 99)
+	fmt.Fprintf(w, "               %0.2f blocks avg, %0.0f max, %d last\n", stats.headLag.Average(), stats.headLag.Max(), stats.lastHeadLag)
+	fmt.Fprintf(w, "               %0.0f p50, %0.0f p95, %0.0f p99\n", percentiles[0], percentiles[1], percentiles[2])
+}
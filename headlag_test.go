@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// headServer responds to eth_blockNumber with the head, and to eth_syncing
+// with the sync status.
+func headServer(head string, syncing string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if head == "" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		result := `"` + head + `"`
+		if bytes.Contains(body, []byte("eth_syncing")) {
+			result = syncing
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":` + result + `}`))
+	}))
+}
+
+func TestHeadMonitor(t *testing.T) {
+	synced := headServer("0xa", "false")
+	defer synced.Close()
+	lagging := headServer("0x8", `{"currentBlock":"0x8","highestBlock":"0xa"}`)
+	defer lagging.Close()
+	broken := headServer("", "")
+	defer broken.Close()
+
+	clients, err := NewClients([]string{synced.URL, lagging.URL, broken.URL}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newHeadMonitor(clients, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Poll()
+	m.Poll()
+
+	tests := []struct {
+		samples, syncing, errors int
+		max                      float64
+	}{
+		{2, 0, 0, 0},
+		{2, 2, 0, 2},
+		{0, 0, 2, 0},
+	}
+	for i, tc := range tests {
+		stats := &clients[i].Stats
+		if stats.headLag.Len() != tc.samples || stats.numSyncing != tc.syncing || stats.numHeadErrors != tc.errors || stats.headLag.Max() != tc.max {
+			t.Errorf("%d: got: %d samples, %d syncing, %d errors, %g max; want: %d, %d, %d, %g", i,
+				stats.headLag.Len(), stats.numSyncing, stats.numHeadErrors, stats.headLag.Max(),
+				tc.samples, tc.syncing, tc.errors, tc.max)
+		}
+	}
+
+	var buf bytes.Buffer
+	clients[1].Stats.renderHeadLag(&buf)
+	if want := "2.00 blocks avg, 2 max, 2 last"; !strings.Contains(buf.String(), want) {
+		t.Errorf("missing %q in:\n%s", want, buf.String())
+	}
+	if got := clients[1].Stats.JSON().HeadLag; got == nil || got.Samples != 2 || got.Last != 2 {
+		t.Errorf("unexpected JSON head lag: %+v", got)
+	}
+}
+
+func TestHeadMonitorClose(t *testing.T) {
+	srv, open := wsConnServer(t, echoResult(`"0xa"`))
+	defer srv.Close()
+
+	clients, err := NewClients([]string{"ws" + strings.TrimPrefix(srv.URL, "http")}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newHeadMonitor(clients, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Poll()
+	m.Close()
+	waitClosed(t, open)
+}
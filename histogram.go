@@ -1,26 +1,119 @@
 package main
 
-import "sort"
+import (
+	"math"
+	"sort"
+)
 
-// TODO: Replace histogram implementation with a sparse bucket based one so
-// it's memory-bounded.
+// defaultPrecision is the relative error of histogram percentiles, unless
+// configured otherwise.
+const defaultPrecision = 0.01
 
+// histogram is a memory-bounded histogram. Values are counted in sparse
+// logarithmic buckets, sized so that any value in a bucket is within the
+// configured relative precision of the bucket's value. Memory grows with the
+// range of values rather than the number of values, e.g. timings between 1µs
+// and 1h at 1% precision fit in about 1100 buckets.
+//
+// Total, Min, Max, Average and Variance are exact, Percentiles are within the
+// precision.
 type histogram struct {
-	all   []float64
+	// Precision is the relative error of percentiles, between 0 and 1. It must
+	// be set before adding values, and defaults to 1%.
+	Precision float64
+
+	logGamma float64        // Log of the ratio between consecutive bucket bounds
+	buckets  map[int]uint64 // Bucket index to count, for positive values
+	zeros    uint64         // Count of values <= 0
+
+	count uint64
 	min   float64
 	max   float64
 	total float64
+	mean  float64 // Running mean and sum of squared deviations, for variance
+	m2    float64
+}
+
+func (h *histogram) init() {
+	if h.buckets != nil {
+		return
+	}
+	if h.Precision <= 0 || h.Precision >= 1 {
+		h.Precision = defaultPrecision
+	}
+	gamma := (1 + h.Precision) / (1 - h.Precision)
+	h.logGamma = math.Log(gamma)
+	h.buckets = map[int]uint64{}
+}
+
+// bucket returns the index of the bucket for a positive value. Bucket i holds
+// values in (gamma^(i-1), gamma^i].
+func (h *histogram) bucket(point float64) int {
+	return int(math.Ceil(math.Log(point) / h.logGamma))
+}
+
+// bucketValue returns the value of bucket i, which is within the precision of
+// every value in the bucket.
+func (h *histogram) bucketValue(i int) float64 {
+	gamma := math.Exp(h.logGamma)
+	return 2 * math.Exp(float64(i)*h.logGamma) / (gamma + 1)
 }
 
 func (h *histogram) Add(point float64) {
-	h.all = append(h.all, point)
-	h.total += point
-	if len(h.all) == 1 || h.min > point {
+	h.init()
+
+	if point > 0 {
+		h.buckets[h.bucket(point)] += 1
+	} else {
+		h.zeros += 1
+	}
+
+	if h.count == 0 || h.min > point {
 		h.min = point
 	}
-	if h.max < point {
+	if h.count == 0 || h.max < point {
 		h.max = point
 	}
+	h.count += 1
+	h.total += point
+
+	// Welford's online variance
+	delta := point - h.mean
+	h.mean += delta / float64(h.count)
+	h.m2 += delta * (point - h.mean)
+}
+
+// Merge adds all the values of other into h. If the precision differs, the
+// merged values are re-bucketed, which compounds their error.
+func (h *histogram) Merge(other *histogram) {
+	if other.count == 0 {
+		return
+	}
+	h.init()
+
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if h.count == 0 || other.max > h.max {
+		h.max = other.max
+	}
+
+	// Combine the variance of both sets
+	count := h.count + other.count
+	delta := other.mean - h.mean
+	h.m2 += other.m2 + delta*delta*float64(h.count)*float64(other.count)/float64(count)
+	h.mean += delta * float64(other.count) / float64(count)
+	h.count = count
+	h.total += other.total
+
+	h.zeros += other.zeros
+	for i, n := range other.buckets {
+		if other.logGamma == h.logGamma {
+			h.buckets[i] += n
+		} else {
+			h.buckets[h.bucket(other.bucketValue(i))] += n
+		}
+	}
 }
 
 func (h *histogram) Total() float64 {
@@ -36,52 +129,56 @@ func (h *histogram) Max() float64 {
 }
 
 func (h *histogram) Average() float64 {
-	return h.total / float64(len(h.all))
+	return h.total / float64(h.count)
 }
 
 func (h *histogram) Variance() float64 {
 	// Population variance
-	mean := h.Average()
-	sum := 0.0
-	for _, v := range h.all {
-		delta := v - mean
-		sum += delta * delta
-	}
-	return sum / float64(h.Len())
+	return h.m2 / float64(h.count)
 }
 
 func (h *histogram) Len() int {
-	return len(h.all)
+	return int(h.count)
 }
 
-// Percentiles takes buckets in whole percentages (e.g. 95 is 95%) and returns
-// a slice with percentile values in the corresponding index. Buckets must be
+// Percentiles takes buckets in percentages (e.g. 99.9 is 99.9%) and returns a
+// slice with percentile values in the corresponding index. Buckets must be
 // in-order.
-func (h *histogram) Percentiles(buckets ...int) []float64 {
+func (h *histogram) Percentiles(buckets ...float64) []float64 {
 	r := make([]float64, len(buckets))
-	if len(h.all) == 0 {
+	if h.count == 0 {
 		return r
 	}
 
-	sort.Float64s(h.all)
+	indexes := make([]int, 0, len(h.buckets))
+	for i := range h.buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
 
-	for i, j := 0, 0; i < len(h.all) || j < len(buckets); i++ {
-		if i >= len(h.all) {
-			// Fill up the remaining buckets with the highest value.
-			r[j] = h.all[len(h.all)-1]
-			j++
+	// Walk the buckets in order, until the rank of each percentile is reached
+	seen := h.zeros
+	next := 0
+	for j, bucket := range buckets {
+		rank := uint64(math.Ceil(bucket*float64(h.count)/100 - 1e-9))
+		if rank >= h.count {
+			r[j] = h.max
 			continue
 		}
-
-		current := i * 100 / len(h.all)
-		if current >= buckets[j] {
-			r[j] = h.all[i]
-			j++
+		if rank < h.zeros {
+			r[j] = h.clamp(0)
+			continue
 		}
-
-		if j >= len(buckets) {
-			break
+		for seen <= rank && next < len(indexes) {
+			seen += h.buckets[indexes[next]]
+			next++
 		}
+		r[j] = h.clamp(h.bucketValue(indexes[next-1]))
 	}
 	return r
 }
+
+// clamp limits a bucket value to the exact range of values.
+func (h *histogram) clamp(v float64) float64 {
+	return math.Min(math.Max(v, h.min), h.max)
+}
@@ -1,6 +1,16 @@
 package main
 
-import "testing"
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// within returns true if got is within the relative precision of want.
+func within(got, want, precision float64) bool {
+	return math.Abs(got-want) <= want*precision
+}
 
 func TestHistogram(t *testing.T) {
 	h := histogram{}
@@ -22,18 +32,11 @@ func TestHistogram(t *testing.T) {
 		t.Errorf("got: %0.4f; want: %0.4f", got, want)
 	}
 
-	percentiles := h.Percentiles(5, 50, 99, 100)
-	if got, want := percentiles[0], 51.0; got != want {
-		t.Errorf("got: %0.4f; want: %0.4f", got, want)
-	}
-	if got, want := percentiles[1], 501.0; got != want {
-		t.Errorf("got: %0.4f; want: %0.4f", got, want)
-	}
-	if got, want := percentiles[2], 991.0; got != want {
-		t.Errorf("got: %0.4f; want: %0.4f", got, want)
-	}
-	if got, want := percentiles[3], 1000.0; got != want {
-		t.Errorf("got: %0.4f; want: %0.4f", got, want)
+	percentiles := h.Percentiles(0, 5, 50, 99, 99.9, 100)
+	for i, want := range []float64{1, 51, 501, 991, 1000, 1000} {
+		if got := percentiles[i]; !within(got, want, h.Precision) {
+			t.Errorf("percentile %d got: %0.4f; want: %0.4f", i, got, want)
+		}
 	}
 }
 
@@ -49,3 +52,59 @@ func TestHistogramVariance(t *testing.T) {
 		t.Errorf("got: %0.4f; want: %0.4f", got, want)
 	}
 }
+
+func TestHistogramPrecision(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	values := make([]float64, 100000)
+	for i := range values {
+		// Long-tailed timings, between 1ms and tens of seconds
+		values[i] = 0.001 * math.Exp(rnd.ExpFloat64()*2)
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	for _, precision := range []float64{0.01, 0.001} {
+		h := histogram{Precision: precision}
+		for _, v := range values {
+			h.Add(v)
+		}
+
+		buckets := []float64{50, 90, 99, 99.9, 99.99}
+		for i, got := range h.Percentiles(buckets...) {
+			rank := int(math.Ceil(buckets[i] * float64(len(sorted)) / 100))
+			want := sorted[rank]
+			if !within(got, want, precision) {
+				t.Errorf("%g precision p%g got: %0.6f; want: %0.6f", precision, buckets[i], got, want)
+			}
+		}
+		if n := len(h.buckets); n > int(math.Log(h.Max()/h.Min())/math.Log((1+precision)/(1-precision)))+2 {
+			t.Errorf("%g precision has too many buckets: %d", precision, n)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b, all := histogram{}, histogram{}, histogram{}
+	for i := 1; i <= 1000; i++ {
+		if i%3 == 0 {
+			a.Add(float64(i))
+		} else {
+			b.Add(float64(i))
+		}
+		all.Add(float64(i))
+	}
+	a.Merge(&b)
+
+	if a.Len() != all.Len() || a.Total() != all.Total() || a.Min() != all.Min() || a.Max() != all.Max() {
+		t.Errorf("got: %d %0.4f %0.4f %0.4f; want: %d %0.4f %0.4f %0.4f", a.Len(), a.Total(), a.Min(), a.Max(), all.Len(), all.Total(), all.Min(), all.Max())
+	}
+	if got, want := a.Variance(), all.Variance(); !within(got, want, 1e-9) {
+		t.Errorf("variance got: %0.4f; want: %0.4f", got, want)
+	}
+	got, want := a.Percentiles(25, 50, 99), all.Percentiles(25, 50, 99)
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("percentile %d got: %0.4f; want: %0.4f", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// jsonrpcID returns a key for the JSON-RPC id of a request or response body,
+// which can be used to correlate responses with their requests. Batches are
+// keyed by their sorted ids, since batch responses can arrive in any order.
+// Returns false if the body is not JSON-RPC or has no id.
+func jsonrpcID(body []byte) (string, bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return "", false
+	}
+
+	type envelope struct {
+		ID json.RawMessage `json:"id"`
+	}
+
+	if body[0] == '[' {
+		var batch []envelope
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return "", false
+		}
+		ids := make([]string, 0, len(batch))
+		for _, msg := range batch {
+			if isNullID(msg.ID) {
+				continue
+			}
+			ids = append(ids, string(msg.ID))
+		}
+		if len(ids) == 0 {
+			return "", false
+		}
+		sort.Strings(ids)
+		return "[" + strings.Join(ids, ",") + "]", true
+	}
+
+	var msg envelope
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return "", false
+	}
+	if isNullID(msg.ID) {
+		return "", false
+	}
+	return string(msg.ID), true
+}
+
+func isNullID(id json.RawMessage) bool {
+	return len(id) == 0 || bytes.Equal(id, []byte("null"))
+}
+
+// jsonrpcMethod returns the method of a JSON-RPC request, or "batch" for batch
+// requests. Returns an empty string if the method can't be parsed.
+func jsonrpcMethod(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return ""
+	}
+	if body[0] == '[' {
+		return "batch"
+	}
+	var msg struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return ""
+	}
+	return msg.Method
+}
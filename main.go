@@ -5,9 +5,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	flags "github.com/jessevdk/go-flags"
@@ -24,17 +27,63 @@ type Options struct {
 		Endpoints []string `positional-arg-name:"endpoint" description:"API endpoint to load test, such as \"http://localhost:8080/\""`
 	} `positional-args:"yes"`
 
-	Timeout     string `long:"timeout" description:"Abort request after duration" default:"30s"`
-	StopAfter   string `long:"stop-after" description:"Stop after N requests per endpoint, N can be a number or duration."`
-	Concurrency int    `long:"concurrency" description:"Concurrent requests per endpoint" default:"1"`
+	// Endpoints from the config file, with their own settings.
+	Endpoints []endpointConfig `no-flag:"yes"`
+
+	Config      string   `long:"config" description:"YAML or JSON file with run options and named endpoints. Flags override the file, and endpoint arguments replace its endpoints."`
+	Timeout     string   `long:"timeout" description:"Abort request after duration" default:"30s"`
+	StopAfter   string   `long:"stop-after" description:"Stop after N requests per endpoint, N can be a number or duration."`
+	Concurrency int      `long:"concurrency" description:"Concurrent requests per endpoint" default:"1"`
+	Rate        string   `long:"rate" description:"Send requests at a constant rate regardless of response times, such as \"500/s\", and time them from when they were scheduled. Requests in flight are still capped by --concurrency for each endpoint."`
+	Stages      []string `long:"stage" description:"Stage of a load profile, instead of a constant rate (options: hold:RATE:DURATION, spike:RATE:DURATION, ramp:FROM-TO:DURATION, steps:FROM-TO:COUNT:DURATION). Can be repeated."`
+	Subscribe   string   `long:"subscribe" description:"Compare eth_subscribe notifications from websocket endpoints instead of requests from stdin, such as \"newHeads\" or JSON params."`
 	//CompareResponse string `long:"compare-response" description:"Load all response bodies and compare between endpoints, will affect throughput." default:"on"`
 
-	//Source string `long:"source" description:"Where requests come from (options: stdin-post, stdin-get)" default:"stdin-jsons"` // Someday: stdin-tcpdump, file://foo.json, ws://remote-endpoint
+	Warmup        string `long:"warmup" description:"Send N requests or for a duration before measuring, which are excluded from the stats."`
+	WarmupCompare bool   `long:"warmup-compare" description:"Compare responses to warm-up requests, and count their mismatches separately."`
+
+	Source string `long:"source" description:"Where requests come from (options: stdin, pcap, pcap:FILE, mismatches:PATH). The pcap source extracts requests from a pcap or pcapng capture, such as from \"tcpdump -w -\" on stdin. The mismatches source replays the requests of a mismatch corpus file or directory." default:"stdin"` // Someday: file://foo.json, ws://remote-endpoint
+
+	PinBlock   string `long:"pin-block" description:"Rewrite \"latest\" and \"pending\" block parameters to a block number, or to the lowest head block of every endpoint with \"head\" (options: N, head)."`
+	PinRefresh string `long:"pin-refresh" description:"How often to refresh the pinned head block." default:"10s"`
+
+	Search         string `long:"search" description:"Search for the maximum rate each endpoint can sustain while meeting an SLO, such as \"p99<200ms,errors<1%\". The rate doubles until the SLO is breached, then the boundary is binary searched."`
+	SearchStart    string `long:"search-start" description:"Rate of the first search trial, which is also the lowest rate searched." default:"10/s"`
+	SearchDuration string `long:"search-duration" description:"Duration of each search trial." default:"10s"`
+
+	Baseline         int  `long:"baseline" description:"Probe each endpoint N times before starting, to measure its baseline latency to connect and to answer a trivial web3_clientVersion request."`
+	SubtractBaseline bool `long:"subtract-baseline" description:"Also report timing with the baseline latency of each endpoint subtracted, to compare endpoints at different network distances."`
+
+	MonitorHead string `long:"monitor-head" description:"Poll the head block of every endpoint at this interval during the run, and report how far each endpoint lags behind the highest head."`
+
+	// Header options apply to every endpoint, unless prefixed with the
+	// endpoint number like "1=Name: value". Values prefixed with "env:" or
+	// "file:" are read from environment variables or files.
+	Headers   []string `long:"header" short:"H" description:"Header to send with requests, as \"Name: value\", or \"N=Name: value\" for only endpoint N (from 0). The value can be \"env:VAR\" or \"file:PATH\". Can be repeated."`
+	BasicAuth []string `long:"basic-auth" description:"Basic auth credentials to send with requests, as \"user:password\", or \"N=user:password\" for only endpoint N. The credentials or password can be \"env:VAR\" or \"file:PATH\"."`
+	Bearer    []string `long:"bearer" description:"Bearer token to send with requests, or \"N=token\" for only endpoint N. The token can be \"env:VAR\" or \"file:PATH\"."`
 
-	// TODO: Specify additional headers/configs per-endpoint (e.g. auth headers)
 	// TODO: Periodic reporting for long-running tests?
 	// TODO: Toggle compare results? Could probably reach higher throughput without result comparison.
-	// TODO: Add latency offcheck set before starting
+
+	SaveRun    string `long:"save-run" description:"Save the requests, response hashes and timing of the run to a file, for comparing with \"versus compare\"."`
+	SaveBodies bool   `long:"save-bodies" description:"Include full response bodies in the saved run."`
+
+	MismatchDir string `long:"mismatch-dir" description:"Write mismatched requests and every endpoint's response to a new JSONL file in the directory, which can be replayed with --source=mismatches:DIR."`
+
+	Reference            string   `long:"reference" description:"Attribute mismatches to the endpoints which differ from a reference: an endpoint number, or the majority response (options: N, majority)."`
+	Recheck              int      `long:"recheck" description:"Re-send mismatched requests to every endpoint up to N times, and only count mismatches which persist."`
+	RecheckDelay         string   `long:"recheck-delay" description:"Wait between rechecks of mismatched requests." default:"1s"`
+	CompareErrorMessages bool     `long:"compare-error-messages" description:"Require JSON-RPC errors to have the same message and data, not just the same code."`
+	Ignore               []string `long:"ignore" description:"Path to remove from responses before comparing, such as \"result.transactions[*].v\". Prefix with \"METHOD:\" to only apply to one method. Can be repeated."`
+	Normalize            []string `long:"normalize" description:"Normalize values before comparing (options: hex-case, leading-zeros, quantities, all). Can be comma-separated or repeated."`
+
+	Diff string `long:"diff" description:"Format of mismatched responses in verbose logs (options: auto, color, plain, raw). Auto is colorized on a terminal, raw writes full bodies." default:"auto"`
+
+	Precision float64 `long:"precision" description:"Relative error of timing percentiles, between 0 and 1, lower values use more memory." default:"0.01"`
+
+	Output     string `long:"output" description:"Format of the report (options: text, json)" default:"text"`
+	ReportFile string `long:"report-file" description:"Write the report to a file instead of stdout."`
 
 	Verbose []bool `long:"verbose" short:"v" description:"Show verbose logging."`
 	Version bool   `long:"version" description:"Print version and exit."`
@@ -46,8 +95,16 @@ func exit(code int, format string, args ...interface{}) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		if err := compareMain(os.Args[2:]); err != nil {
+			exit(1, "%s\n", err)
+		}
+		return
+	}
+
 	options := Options{}
-	p, err := flags.NewParser(&options, flags.Default).ParseArgs(os.Args[1:])
+	parser := flags.NewParser(&options, flags.Default)
+	p, err := parser.ParseArgs(os.Args[1:])
 	if err != nil {
 		if p == nil {
 			fmt.Println(err)
@@ -60,7 +117,13 @@ func main() {
 		os.Exit(0)
 	}
 
-	if len(options.Args.Endpoints) == 0 {
+	if options.Config != "" {
+		if err := loadConfig(parser, &options, options.Config); err != nil {
+			exit(1, "%s\n", err)
+		}
+	}
+
+	if len(options.Args.Endpoints) == 0 && len(options.Endpoints) == 0 {
 		exit(1, "must specify at least one endpoint\n")
 	}
 
@@ -91,6 +154,12 @@ func main() {
 	}
 }
 
+// isTerminal returns true if the file is a character device, like a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 func parseStopAfter(s string) (time.Duration, int, error) {
 	n, err := strconv.ParseUint(s, 10, 32)
 	if err == nil {
@@ -129,6 +198,39 @@ func run(ctx context.Context, options Options) error {
 		timeout = d
 	}
 
+	var render func(*report, io.Writer) error
+	switch options.Output {
+	case "text":
+		render = (*report).Render
+	case "json":
+		render = (*report).RenderJSON
+	default:
+		return fmt.Errorf("invalid output format: %s", options.Output)
+	}
+
+	cmp := &comparator{CompareErrorMessages: options.CompareErrorMessages}
+	for _, s := range options.Ignore {
+		rule, err := parseIgnoreRule(s)
+		if err != nil {
+			return err
+		}
+		cmp.Ignore = append(cmp.Ignore, rule)
+	}
+	normalize, err := parseNormalizer(options.Normalize)
+	if err != nil {
+		return err
+	}
+	cmp.Normalize = normalize
+
+	diff, err := parseDiffFormat(options.Diff, isTerminal(os.Stderr))
+	if err != nil {
+		return err
+	}
+
+	if options.Precision <= 0 || options.Precision >= 1 {
+		return fmt.Errorf("precision must be between 0 and 1: %g", options.Precision)
+	}
+
 	if options.Concurrency < 1 {
 		logger.Info().Int("concurrency", options.Concurrency).Msg("concurrency is less than 1, overriding to 1")
 		options.Concurrency = 1
@@ -136,58 +238,346 @@ func run(ctx context.Context, options Options) error {
 
 	g, ctx := errgroup.WithContext(ctx)
 
-	respBuffer := options.Concurrency * 4
+	endpoints := options.Endpoints
+	for _, endpoint := range options.Args.Endpoints {
+		endpoints = append(endpoints, endpointConfig{URL: endpoint})
+	}
+
+	// Launch clients
+	clients, err := newEndpointClients(endpoints, options.Concurrency, timeout)
+	if err != nil {
+		return fmt.Errorf("failed to create clients: %w", err)
+	}
+
+	for _, c := range clients {
+		c.Stats.timing.Precision = options.Precision
+	}
+
+	headers, err := endpointHeaders(len(clients), endpointHeaderOptions(endpoints, headerOptions{
+		Headers:   options.Headers,
+		BasicAuth: options.BasicAuth,
+		Bearer:    options.Bearer,
+	}))
+	if err != nil {
+		return err
+	}
+	for i, c := range clients {
+		c.Header = headers[i]
+	}
+
+	if options.SubtractBaseline && options.Baseline < 1 {
+		return fmt.Errorf("--subtract-baseline requires --baseline")
+	}
+	if options.Baseline > 0 {
+		logger.Info().Int("probes", options.Baseline).Msg("probing baseline latency of endpoints")
+		if err := probeBaselines(ctx, clients, options.Baseline, options.SubtractBaseline); err != nil {
+			return err
+		}
+	}
+
+	respBuffer := 0
+	for _, c := range clients {
+		if c.Concurrency*4 > respBuffer {
+			respBuffer = c.Concurrency * 4
+		}
+	}
 	if respBuffer < 50 {
 		respBuffer = 50
 	}
 	// responses is closed when clients are shut down
 	responses := make(chan Response, respBuffer)
 
-	// Launch clients
-	clients, err := NewClients(options.Args.Endpoints, options.Concurrency, timeout)
+	// Stopped before rendering the report, which includes the head lag
+	stopMonitor := func() {}
+	if options.MonitorHead != "" {
+		interval, err := time.ParseDuration(options.MonitorHead)
+		if err != nil {
+			return fmt.Errorf("failed to parse head monitor interval: %w", err)
+		} else if interval <= 0 {
+			return fmt.Errorf("head monitor interval must be positive: %s", options.MonitorHead)
+		}
+		m, err := newHeadMonitor(clients, interval)
+		if err != nil {
+			return fmt.Errorf("failed to create head monitor: %w", err)
+		}
+		monitorCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			m.Run(monitorCtx)
+		}()
+		var once sync.Once
+		stopMonitor = func() {
+			once.Do(func() {
+				cancel()
+				<-done
+				m.Close()
+			})
+		}
+		defer stopMonitor()
+	}
+
+	// Open the source before writing any mismatches, which it could be reading
+	var src source
+	if options.Subscribe == "" {
+		src, err = newSource(options.Source, os.Stdin)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		if options.PinBlock != "" {
+			pinner, err := newBlockPinner(options.PinBlock, options.PinRefresh, clients)
+			if err != nil {
+				return err
+			}
+			defer pinner.Close()
+			src.lineScanner = pinner.Pin(src.lineScanner)
+			if pinner.Refreshing() {
+				pinCtx, stopPinning := context.WithCancel(ctx)
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					pinner.Run(pinCtx)
+				}()
+				// Stopped before the pinner is closed
+				defer func() {
+					stopPinning()
+					<-done
+				}()
+			}
+		}
+	}
+
+	ref, err := parseReference(options.Reference, len(clients))
 	if err != nil {
-		return fmt.Errorf("failed to create clients: %w", err)
+		return err
+	}
+
+	var rc *rechecker
+	if options.Recheck > 0 {
+		delay, err := time.ParseDuration(options.RecheckDelay)
+		if err != nil {
+			return fmt.Errorf("failed to parse recheck delay: %w", err)
+		}
+		if rc, err = newRechecker(clients, options.Recheck, delay); err != nil {
+			return fmt.Errorf("failed to create rechecker: %w", err)
+		}
+		defer rc.Close()
+		rc.Comparator = cmp
 	}
 
-	r := report{Clients: clients}
+	var sched *schedule
+	if options.Rate != "" && len(options.Stages) > 0 {
+		return fmt.Errorf("--rate and --stage can't be used together")
+	} else if options.Rate != "" {
+		interval, err := parseRate(options.Rate)
+		if err != nil {
+			return err
+		}
+		sched = &schedule{Interval: interval}
+	} else if len(options.Stages) > 0 {
+		sched = &schedule{}
+		for _, s := range options.Stages {
+			stages, err := parseStage(s)
+			if err != nil {
+				return err
+			}
+			sched.Stages = append(sched.Stages, stages...)
+		}
+	}
+
+	if sched != nil {
+		// Requests are sent on schedule to every endpoint, even when another
+		// endpoint is busy
+		clients.Backlog(maxScheduledBacklog)
+	}
+
+	var search *searcher
+	if options.Search != "" {
+		if sched != nil || options.Subscribe != "" {
+			return fmt.Errorf("--search can't be used with --rate, --stage or --subscribe")
+		}
+		search, err = newSearcher(src, options.Search, options.SearchStart, options.SearchDuration)
+		if err != nil {
+			return err
+		}
+		search.Precision = options.Precision
+	}
+
+	warm, err := parseWarmup(options.Warmup)
+	if err != nil {
+		return fmt.Errorf("failed to parse warm-up: %w", err)
+	}
+	if warm != nil && (search != nil || options.Subscribe != "") {
+		return fmt.Errorf("--warmup can't be used with --search or --subscribe")
+	}
+
+	r := report{
+		Clients:    clients,
+		Comparator: cmp,
+		Reference:  ref,
+		Recheck:    rc,
+		Schedule:   sched,
+		Search:     search,
+
+		WarmupCompare: options.WarmupCompare,
+
+		// Each endpoint is searched with different requests
+		skipCompare: search != nil,
+	}
 	g.Go(func() error {
 		return r.Serve(ctx, responses)
 	})
+	var aw *artifactWriter
+	if options.SaveRun != "" {
+		aw, err = newArtifactWriter(options.SaveRun, clients)
+		if err != nil {
+			return err
+		}
+		aw.SaveBodies = options.SaveBodies
+		r.CompletedResponses = aw.Write
+	}
+	var mw *mismatchWriter
+	if options.MismatchDir != "" {
+		mw, err = newMismatchWriter(options.MismatchDir, clients)
+		if err != nil {
+			return err
+		}
+		r.MismatchedResponse = mw.Write
+		logger.Info().Str("path", mw.Path).Msg("writing mismatches")
+	}
 	if len(options.Verbose) > 0 {
+		next := r.MismatchedResponse
 		r.MismatchedResponse = func(resps []Response) {
-			logger.Info().Int("id", int(resps[0].ID)).Msgf("mismatched responses: %s", Responses(resps).String())
+			logger.Info().Int("id", int(resps[0].ID)).Msgf("mismatched responses: %s", Responses(resps).Diff(cmp, diff))
+			if next != nil {
+				next(resps)
+			}
 		}
 	}
 
-	g.Go(func() error {
-		defer close(responses)
-		return clients.Serve(ctx, responses)
-	})
+	if options.Subscribe != "" {
+		params, err := subscribeParams(options.Subscribe)
+		if err != nil {
+			return err
+		}
+		g.Go(func() error {
+			defer close(responses)
+			return subscribe(ctx, clients, params, responses, stopAfter, timeout)
+		})
+
+		logger.Info().Int("clients", len(clients)).Msg("started endpoint subscriptions")
+	} else if search != nil {
+		g.Go(func() error {
+			defer close(responses)
+			return search.Run(ctx, clients, responses)
+		})
+
+		logger.Info().Int("clients", len(clients)).Str("slo", search.SLO.String()).Msg("searching for the maximum sustainable rate, waiting for stdin")
+	} else {
+		g.Go(func() error {
+			defer close(responses)
+			return clients.Serve(ctx, responses)
+		})
+
+		logger.Info().Int("clients", len(clients)).Msg("started endpoint clients, waiting for stdin")
+
+		g.Go(func() error {
+			return pump(ctx, src, clients, stopAfter, r.Schedule, warm)
+		})
+	}
 
-	logger.Info().Int("clients", len(clients)).Msg("started endpoint clients, waiting for stdin")
+	serveErr := g.Wait()
+	if serveErr == context.Canceled {
+		// Shutting down
+		serveErr = nil
+	}
 
-	g.Go(func() error {
-		return pump(ctx, os.Stdin, clients, stopAfter)
-	})
+	stopMonitor()
 
-	if err := g.Wait(); err == context.Canceled {
-		// Shutting down
-	} else if err != nil {
-		return fmt.Errorf("failed to serve: %w", err)
+	// The run and mismatches are saved up to a serve error, which is still
+	// returned instead of any error saving them
+	if aw != nil {
+		if err := aw.Close(); err != nil && serveErr == nil {
+			return fmt.Errorf("failed to save run: %w", err)
+		}
+	}
+	if mw != nil {
+		if err := mw.Close(); err != nil && serveErr == nil {
+			return fmt.Errorf("failed to save mismatches: %w", err)
+		}
+	}
+	if serveErr != nil {
+		return fmt.Errorf("failed to serve: %w", serveErr)
 	}
 
 	// Report
-	return r.Render(os.Stdout)
+	if options.ReportFile == "" {
+		return render(&r, os.Stdout)
+	}
+	f, err := os.Create(options.ReportFile)
+	if err != nil {
+		return fmt.Errorf("failed to create report file: %w", err)
+	}
+	if err := render(&r, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-// pump takes lines from a reader and pumps them into the clients
-func pump(ctx context.Context, r io.Reader, clients Clients, stopAfter int) error {
-	defer clients.Finalize()
+// lineScanner is a source of request payloads, like bufio.Scanner.
+type lineScanner interface {
+	Scan() bool
+	Bytes() []byte
+	Err() error
+}
+
+// source is a lineScanner of requests that must be closed when done.
+type source struct {
+	lineScanner
+	io.Closer
+}
+
+// newSource creates the request source named by the --source option.
+func newSource(name string, stdin io.Reader) (source, error) {
+	var r io.Reader = stdin
+	var closer io.Closer = ioutil.NopCloser(nil)
+	if parts := strings.SplitN(name, ":", 2); len(parts) == 2 && parts[0] == "mismatches" {
+		var err error
+		if r, closer, err = openMismatches(parts[1]); err != nil {
+			return source{}, err
+		}
+		name = parts[0]
+	} else if len(parts) == 2 {
+		f, err := os.Open(parts[1])
+		if err != nil {
+			return source{}, fmt.Errorf("failed to open source: %w", err)
+		}
+		name, r, closer = parts[0], f, f
+	}
 
-	scanner := bufio.NewScanner(r)
-	// Some lines are really long, let's allocate a big fat megabyte for lines.
-	buf := make([]byte, 1024*1024)
-	scanner.Buffer(buf, cap(buf))
+	switch name {
+	case "stdin":
+		scanner := bufio.NewScanner(r)
+		// Some lines are really long, let's allocate a big fat megabyte for lines.
+		buf := make([]byte, 1024*1024)
+		scanner.Buffer(buf, cap(buf))
+		return source{scanner, closer}, nil
+	case "pcap":
+		return source{newPcapScanner(r), closer}, nil
+	case "mismatches":
+		return source{newMismatchScanner(r), closer}, nil
+	}
+	closer.Close()
+	return source{}, fmt.Errorf("invalid source: %s", name)
+}
+
+// pump takes lines from a scanner and pumps them into the clients, as fast as
+// the clients take them or paced by the schedule if it's set
+func pump(ctx context.Context, scanner lineScanner, clients Clients, stopAfter int, sched *schedule, warm *warmup) error {
+	defer clients.Finalize()
 
 	n := 0
 	for scanner.Scan() {
@@ -202,9 +592,34 @@ func pump(ctx context.Context, r io.Reader, clients Clients, stopAfter int) erro
 			logger.Debug().Msg("reached end of feed")
 			return nil
 		}
-		if err := clients.Send(ctx, line); err != nil {
+		// The scanner reuses its buffer, but the line outlives this iteration
+		req := Request{
+			Line:      append([]byte(nil), line...),
+			Timestamp: time.Now(),
+		}
+		if sched != nil {
+			at, stage, err := sched.Next(ctx)
+			if err == errScheduleDone {
+				logger.Info().Msgf("stopping request feed after the load profile, %d requests", n)
+				return nil
+			} else if err != nil {
+				return err
+			}
+			req.Timestamp, req.Scheduled, req.Stage = at, true, stage
+		}
+		// Decided after waiting for the schedule, by when the request is sent
+		req.Warmup = warm != nil && warm.Next(req.Timestamp)
+		if err := clients.SendRequest(ctx, req); err != nil {
 			return err
 		}
+		if sched != nil && !req.Warmup {
+			// Warm-up requests are paced, but not counted as sent
+			sched.Sent(req.Timestamp, req.Stage)
+		}
+		if req.Warmup {
+			// Warm-up requests don't count towards stopping
+			continue
+		}
 		n += 1
 
 		if stopAfter > 0 && n >= stopAfter {
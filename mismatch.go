@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// mismatchRecord is a line of a mismatch corpus, with a mismatched request and
+// the response of every endpoint. Records are self-contained, so that they can
+// be shared and replayed with the mismatches source.
+type mismatchRecord struct {
+	ID        requestID          `json:"id"`
+	Time      time.Time          `json:"time"`
+	Method    string             `json:"method,omitempty"`
+	Request   json.RawMessage    `json:"request"`
+	Responses []mismatchResponse `json:"responses"`
+}
+
+type mismatchResponse struct {
+	Endpoint int             `json:"endpoint"`
+	Name     string          `json:"name,omitempty"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Status   int             `json:"status,omitempty"` // HTTP status, for error responses
+	Elapsed  float64         `json:"elapsed"`          // Seconds
+}
+
+// rawJSON returns the body as JSON, or as a JSON string if it's not valid JSON.
+func rawJSON(body []byte) json.RawMessage {
+	if json.Valid(body) {
+		return body
+	}
+	b, _ := json.Marshal(string(body))
+	return b
+}
+
+// mismatchWriter writes every mismatched set of responses of a run to a new
+// JSONL file in the corpus directory.
+type mismatchWriter struct {
+	Path string
+
+	f       *os.File
+	w       *bufio.Writer
+	enc     *json.Encoder
+	clients map[*Client]int
+	err     error
+}
+
+func newMismatchWriter(dir string, clients Clients) (*mismatchWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create mismatch directory: %w", err)
+	}
+	path := filepath.Join(dir, "mismatches-"+time.Now().Format("20060102T150405")+".jsonl")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mismatch file: %w", err)
+	}
+	mw := &mismatchWriter{
+		Path:    path,
+		f:       f,
+		w:       bufio.NewWriter(f),
+		clients: make(map[*Client]int, len(clients)),
+	}
+	mw.enc = json.NewEncoder(mw.w)
+	for i, c := range clients {
+		mw.clients[c] = i
+	}
+	return mw, nil
+}
+
+// Write records a mismatched set of responses to the same request. Errors are
+// returned by Close.
+func (mw *mismatchWriter) Write(resps []Response) {
+	if mw.err != nil || len(resps) == 0 {
+		return
+	}
+
+	record := mismatchRecord{
+		ID:        resps[0].ID,
+		Responses: make([]mismatchResponse, 0, len(resps)),
+	}
+	if req := resps[0].Request; req != nil {
+		record.Time = req.Timestamp
+		record.Method = req.Method
+		record.Request = rawJSON(req.Line)
+	}
+	for _, resp := range resps {
+		r := mismatchResponse{
+			Endpoint: mw.clients[resp.client],
+			Elapsed:  resp.Elapsed.Seconds(),
+		}
+		if resp.client != nil {
+			r.Name = resp.client.Name
+			r.URL = resp.client.Endpoint
+		}
+		if resp.Err != nil {
+			r.Error = resp.Err.Error()
+			var status statusError
+			if errors.As(resp.Err, &status) {
+				r.Status = status.StatusCode
+			}
+		}
+		if resp.Body != nil {
+			r.Body = rawJSON(resp.Body)
+		}
+		record.Responses = append(record.Responses, r)
+	}
+	sort.Slice(record.Responses, func(i, j int) bool {
+		return record.Responses[i].Endpoint < record.Responses[j].Endpoint
+	})
+
+	mw.err = mw.enc.Encode(record)
+	if mw.err == nil {
+		// Flush every record, so the corpus is usable if the run is interrupted
+		mw.err = mw.w.Flush()
+	}
+}
+
+func (mw *mismatchWriter) Close() error {
+	if err := mw.w.Flush(); err != nil && mw.err == nil {
+		mw.err = err
+	}
+	if err := mw.f.Close(); err != nil && mw.err == nil {
+		mw.err = err
+	}
+	return mw.err
+}
+
+// mismatchScanner is a lineScanner of the requests in a mismatch corpus.
+type mismatchScanner struct {
+	dec  *json.Decoder
+	line []byte
+	err  error
+}
+
+func newMismatchScanner(r io.Reader) *mismatchScanner {
+	return &mismatchScanner{dec: json.NewDecoder(bufio.NewReader(r))}
+}
+
+func (s *mismatchScanner) Scan() bool {
+	for s.err == nil {
+		var record mismatchRecord
+		if err := s.dec.Decode(&record); err == io.EOF {
+			return false
+		} else if err != nil {
+			s.err = fmt.Errorf("failed to read mismatch record: %w", err)
+			return false
+		}
+		if len(record.Request) == 0 {
+			continue
+		}
+		// Requests are saved as JSON, or as a JSON string if they were not
+		var line string
+		if err := json.Unmarshal(record.Request, &line); err == nil {
+			s.line = []byte(line)
+		} else {
+			s.line = record.Request
+		}
+		return true
+	}
+	return false
+}
+
+func (s *mismatchScanner) Bytes() []byte {
+	return s.line
+}
+
+func (s *mismatchScanner) Err() error {
+	return s.err
+}
+
+// openMismatches opens a mismatch corpus file, or every corpus file in a
+// directory in order.
+func openMismatches(path string) (io.Reader, io.Closer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open source: %w", err)
+	}
+	paths := []string{path}
+	if info.IsDir() {
+		if paths, err = filepath.Glob(filepath.Join(path, "mismatches-*.jsonl")); err != nil {
+			return nil, nil, err
+		}
+		sort.Strings(paths)
+	}
+
+	var readers []io.Reader
+	var files multiCloser
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			files.Close()
+			return nil, nil, fmt.Errorf("failed to open source: %w", err)
+		}
+		readers = append(readers, f)
+		files = append(files, f)
+	}
+	return io.MultiReader(readers...), files, nil
+}
+
+type multiCloser []io.Closer
+
+func (c multiCloser) Close() error {
+	var err error
+	for _, closer := range c {
+		if e := closer.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMismatchCorpus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "versus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clients, err := NewClients([]string{"noop://foo", "noop://bar"}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mw, err := newMismatchWriter(dir, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{`{"id":1,"method":"eth_blockNumber"}`, `not json`}
+	for i, line := range lines {
+		req := &Request{ID: requestID(i), Line: []byte(line), Method: jsonrpcMethod([]byte(line))}
+		mw.Write([]Response{
+			{client: clients[1], Request: req, ID: req.ID, Err: statusError{502}, Elapsed: time.Second},
+			{client: clients[0], Request: req, ID: req.ID, Body: []byte(`{"id":1,"result":"0x1"}`), Elapsed: time.Millisecond},
+		})
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(mw.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Check the first record
+	var record mismatchRecord
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := record.Method, "eth_blockNumber"; got != want {
+		t.Errorf("method: got: %q; want: %q", got, want)
+	}
+	if len(record.Responses) != 2 {
+		t.Fatalf("got %d responses; want 2", len(record.Responses))
+	}
+	first, second := record.Responses[0], record.Responses[1]
+	if first.Endpoint != 0 || first.URL != "noop://foo" || string(first.Body) != `{"id":1,"result":"0x1"}` {
+		t.Errorf("first response: got: %+v", first)
+	}
+	if second.Endpoint != 1 || second.Status != 502 || second.Error != "bad status code: 502" || second.Elapsed != 1 {
+		t.Errorf("second response: got: %+v", second)
+	}
+
+	// Replay the requests from the directory
+	src, err := newSource("mismatches:"+dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	var got []string
+	for src.Scan() {
+		got = append(got, string(src.Bytes()))
+	}
+	if err := src.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(lines) {
+		t.Errorf("got: %q; want: %q", got, lines)
+	}
+
+	if _, err := newSource("mismatches:"+filepath.Join(dir, "missing"), nil); err == nil {
+		t.Error("expected error for missing corpus")
+	}
+}
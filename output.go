@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// reportVersion is the version of the JSON report schema. It must be
+// incremented when fields are removed or their meaning changes, adding fields
+// is fine.
+const reportVersion = 1
+
+// jsonReport is the JSON report schema. Durations are in seconds and rates
+// are fractions between 0 and 1.
+type jsonReport struct {
+	Version   int            `json:"version"`
+	Endpoints []jsonEndpoint `json:"endpoints"`
+	Summary   jsonSummary    `json:"summary"`
+
+	// MismatchGroups are mismatches grouped by signature, by descending count
+	MismatchGroups []jsonMismatchGroup `json:"mismatch_groups"`
+}
+
+type jsonEndpoint struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name,omitempty"`
+	Endpoint string `json:"endpoint"`
+
+	Requests          int            `json:"requests"`
+	Errors            int            `json:"errors"`
+	ErrorRate         float64        `json:"error_rate"`
+	RequestsPerSecond float64        `json:"requests_per_second"`
+	ErrorsPerSecond   float64        `json:"errors_per_second"`
+	Timing            jsonTiming     `json:"timing"`
+	ErrorMessages     map[string]int `json:"error_messages"`
+	Diverged          int            `json:"diverged"` // Mismatches attributed to the endpoint by the reference
+	Warmup            int            `json:"warmup"`   // Warm-up requests, excluded from the other stats
+	WarmupErrors      int            `json:"warmup_errors"`
+
+	// Methods are keyed by JSON-RPC method, with "" for unknown methods
+	Methods map[string]jsonMethod `json:"methods"`
+
+	Notifications *jsonNotifications `json:"notifications,omitempty"`
+	HeadLag       *jsonHeadLag       `json:"head_lag,omitempty"`
+	Baseline      *jsonBaseline      `json:"baseline,omitempty"`
+
+	// Phases of HTTP requests are keyed by phase, like "first_byte"
+	Phases map[string]jsonPhase `json:"phases,omitempty"`
+}
+
+type jsonPhase struct {
+	Requests int        `json:"requests"` // Requests which went through the phase
+	Timing   jsonTiming `json:"timing"`
+}
+
+// jsonBaseline is the latency of an endpoint probed before the run.
+type jsonBaseline struct {
+	Connect  jsonTiming  `json:"connect"`            // TCP connect times
+	Request  jsonTiming  `json:"request"`            // Round trip times of a trivial request
+	Offset   float64     `json:"offset"`             // Subtracted from the adjusted timing
+	Adjusted *jsonTiming `json:"adjusted,omitempty"` // Timing with the offset subtracted
+}
+
+type jsonTiming struct {
+	Average     float64            `json:"avg"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	StdDev      float64            `json:"stddev"`
+	Percentiles map[string]float64 `json:"percentiles"` // Keyed like "p99"
+}
+
+type jsonMethod struct {
+	Requests   int        `json:"requests"`
+	Errors     int        `json:"errors"`
+	ErrorRate  float64    `json:"error_rate"`
+	Mismatched int        `json:"mismatched"`
+	Timing     jsonTiming `json:"timing"`
+}
+
+type jsonNotifications struct {
+	Late       int `json:"late"`
+	Duplicated int `json:"duplicated"`
+	Missing    int `json:"missing"`
+}
+
+// jsonHeadLag is the lag of an endpoint's head block behind the highest head,
+// in blocks.
+type jsonHeadLag struct {
+	Samples int        `json:"samples"`
+	Syncing int        `json:"syncing"`
+	Errors  int        `json:"errors"`
+	Last    uint64     `json:"last"`
+	Lag     jsonTiming `json:"lag"`
+}
+
+type jsonSummary struct {
+	Endpoints  int     `json:"endpoints"`
+	Completed  int     `json:"completed"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"`
+	Mismatched int     `json:"mismatched"`           // Mismatched response sets, once per request
+	Ungrouped  int     `json:"ungrouped_mismatches"` // Mismatches beyond the limit of mismatch groups
+	Undecided  int     `json:"undecided"`            // Mismatches without a reference response
+	Transient  int     `json:"transient"`            // Mismatches which matched on a recheck
+	Persistent int     `json:"persistent"`           // Mismatches which didn't match on any recheck
+	Incomplete int     `json:"incomplete"`
+	Overloaded int     `json:"overloaded"`
+
+	// Warm-up requests are excluded from the other counts
+	Warmup           int `json:"warmup"`
+	WarmupErrors     int `json:"warmup_errors"`
+	WarmupMismatched int `json:"warmup_mismatched"`
+
+	AverageRequest float64 `json:"avg_request"`
+	RunTime        float64 `json:"run_time"`
+
+	Schedule *jsonSchedule `json:"schedule,omitempty"`
+	Search   *jsonSearch   `json:"search,omitempty"`
+}
+
+// jsonSearch is the maximum sustainable rate found for each endpoint.
+type jsonSearch struct {
+	SLO       string               `json:"slo"`
+	Endpoints []jsonSearchEndpoint `json:"endpoints"`
+}
+
+type jsonSearchEndpoint struct {
+	Max      float64           `json:"max"`      // Highest requests per second which met the SLO
+	Breached float64           `json:"breached"` // Lowest requests per second which breached the SLO
+	Trials   []jsonSearchTrial `json:"trials"`
+	Error    string            `json:"error,omitempty"` // Why the search stopped early
+}
+
+type jsonSearchTrial struct {
+	Rate     float64 `json:"rate"`
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	Latency  float64 `json:"latency"` // At the SLO percentile
+	Met      bool    `json:"met"`
+}
+
+// jsonSchedule is how closely requests kept up with the constant rate.
+type jsonSchedule struct {
+	Rate     float64    `json:"rate"`      // Target requests per second
+	SentRate float64    `json:"sent_rate"` // Actual requests per second
+	Late     int        `json:"late"`      // Requests sent more than an interval behind schedule
+	Behind   jsonTiming `json:"behind"`    // Time between the scheduled and actual send time
+
+	Stages []jsonStage `json:"stages,omitempty"` // Stages of the load profile
+}
+
+type jsonStage struct {
+	Name      string              `json:"name"`
+	From      float64             `json:"from"` // Target requests per second at the start of the stage
+	To        float64             `json:"to"`   // Target requests per second at the end of the stage
+	Duration  float64             `json:"duration"`
+	Sent      int                 `json:"sent"`
+	Endpoints []jsonStageEndpoint `json:"endpoints"`
+}
+
+type jsonStageEndpoint struct {
+	Requests  int        `json:"requests"`
+	Errors    int        `json:"errors"`
+	ErrorRate float64    `json:"error_rate"`
+	Timing    jsonTiming `json:"timing"`
+}
+
+type jsonMismatchGroup struct {
+	Method    string   `json:"method"`
+	Endpoints [][]int  `json:"endpoints"` // Endpoint indexes, grouped by equivalent responses
+	Paths     []string `json:"paths"`
+	Count     int      `json:"count"`
+	Example   struct {
+		Request string `json:"request,omitempty"`
+		Diff    string `json:"diff"`
+	} `json:"example"`
+}
+
+// ratio returns a/b, or 0 when it's not a finite number (which can't be
+// encoded in JSON).
+func ratio(a, b float64) float64 {
+	r := a / b
+	if math.IsNaN(r) || math.IsInf(r, 0) {
+		return 0
+	}
+	return r
+}
+
+func jsonHistogram(h *histogram) jsonTiming {
+	timing := jsonTiming{
+		Min:         h.Min(),
+		Max:         h.Max(),
+		Percentiles: map[string]float64{},
+	}
+	if h.Len() > 0 {
+		timing.Average = h.Average()
+		timing.StdDev = math.Sqrt(h.Variance())
+	}
+	percentiles := h.Percentiles(percentileBuckets...)
+	for i, bucket := range percentileBuckets {
+		timing.Percentiles[fmt.Sprintf("p%g", bucket)] = percentiles[i]
+	}
+	return timing
+}
+
+func (stats *clientStats) JSON() jsonEndpoint {
+	concurrency := 1
+	if stats.Concurrency > 0 {
+		concurrency = stats.Concurrency
+	}
+
+	r := jsonEndpoint{
+		Requests:          stats.numTotal,
+		Errors:            stats.numErrors,
+		ErrorRate:         ratio(float64(stats.numErrors), float64(stats.numTotal)),
+		RequestsPerSecond: ratio(float64(stats.numTotal*concurrency), stats.timing.Total()),
+		ErrorsPerSecond:   ratio(float64(stats.numErrors), stats.timeErrors.Seconds()),
+		Timing:            jsonHistogram(&stats.timing),
+		ErrorMessages:     map[string]int{},
+		Diverged:          stats.numDiverged,
+		Warmup:            stats.numWarmup,
+		WarmupErrors:      stats.numWarmupErrors,
+	}
+	for msg, num := range stats.errors {
+		r.ErrorMessages[msg] = num
+	}
+	r.Methods = make(map[string]jsonMethod, len(stats.methods))
+	for method, m := range stats.methods {
+		r.Methods[method] = jsonMethod{
+			Requests:   m.numTotal,
+			Errors:     m.numErrors,
+			ErrorRate:  ratio(float64(m.numErrors), float64(m.numTotal)),
+			Mismatched: m.numMismatched,
+			Timing:     jsonHistogram(&m.timing),
+		}
+	}
+	for i := range stats.phases {
+		h := &stats.phases[i]
+		if h.Len() == 0 {
+			continue
+		}
+		if r.Phases == nil {
+			r.Phases = map[string]jsonPhase{}
+		}
+		r.Phases[phase(i).String()] = jsonPhase{
+			Requests: h.Len(),
+			Timing:   jsonHistogram(h),
+		}
+	}
+	if b := stats.baseline; b != nil {
+		r.Baseline = &jsonBaseline{
+			Connect: jsonHistogram(&b.connect),
+			Request: jsonHistogram(&b.request),
+			Offset:  b.Offset().Seconds(),
+		}
+		if stats.subtractBaseline {
+			adjusted := jsonHistogram(&stats.adjusted)
+			r.Baseline.Adjusted = &adjusted
+		}
+	}
+	if stats.headLag.Len() > 0 || stats.numHeadErrors > 0 {
+		r.HeadLag = &jsonHeadLag{
+			Samples: stats.headLag.Len(),
+			Syncing: stats.numSyncing,
+			Errors:  stats.numHeadErrors,
+			Last:    stats.lastHeadLag,
+			Lag:     jsonHistogram(&stats.headLag),
+		}
+	}
+	if stats.Subscription {
+		r.RequestsPerSecond = 0
+		r.Notifications = &jsonNotifications{
+			Late:       stats.numLate,
+			Duplicated: stats.numDuplicates,
+			Missing:    stats.numMissing,
+		}
+	}
+	return r
+}
+
+func (r *report) JSON() jsonReport {
+	out := jsonReport{
+		Version:   reportVersion,
+		Endpoints: make([]jsonEndpoint, 0, len(r.Clients)),
+		Summary: jsonSummary{
+			Endpoints:  len(r.Clients),
+			Completed:  r.completed,
+			Requests:   r.requests,
+			Errors:     r.errors,
+			ErrorRate:  ratio(float64(r.errors), float64(r.requests)),
+			Mismatched: r.mismatched,
+			Ungrouped:  r.groups.other,
+			Undecided:  r.undecided,
+			Transient:  r.transient,
+			Persistent: r.persistent,
+			Incomplete: len(r.pendingResponses),
+			Overloaded: r.overloaded,
+
+			Warmup:           r.warmup,
+			WarmupErrors:     r.warmupErrors,
+			WarmupMismatched: r.warmupMismatched,
+
+			AverageRequest: ratio(r.elapsed.Seconds(), float64(r.requests)),
+			RunTime:        time.Now().Sub(r.started).Seconds(),
+		},
+	}
+	if r.Schedule != nil {
+		out.Summary.Schedule = &jsonSchedule{
+			Rate:     r.Schedule.Rate(),
+			SentRate: r.Schedule.SentRate(),
+			Late:     r.Schedule.late,
+			Behind:   jsonHistogram(&r.Schedule.behind),
+		}
+		for i, st := range r.Schedule.Stages {
+			stage := jsonStage{
+				Name:     st.Name,
+				From:     st.From,
+				To:       st.To,
+				Duration: st.Duration.Seconds(),
+			}
+			if r.Schedule.sent != nil {
+				stage.Sent = r.Schedule.sent[i]
+			}
+			if r.stages != nil {
+				for j := range r.stages[i] {
+					stats := &r.stages[i][j]
+					stage.Endpoints = append(stage.Endpoints, jsonStageEndpoint{
+						Requests:  stats.numTotal,
+						Errors:    stats.numErrors,
+						ErrorRate: ratio(float64(stats.numErrors), float64(stats.numTotal)),
+						Timing:    jsonHistogram(&stats.timing),
+					})
+				}
+			}
+			out.Summary.Schedule.Stages = append(out.Summary.Schedule.Stages, stage)
+		}
+	}
+	if r.Search != nil {
+		out.Summary.Search = &jsonSearch{SLO: r.Search.SLO.String()}
+		for _, result := range r.Search.Results() {
+			e := jsonSearchEndpoint{
+				Max:      result.Max,
+				Breached: result.Breached,
+				Trials:   make([]jsonSearchTrial, 0, len(result.Trials)),
+			}
+			for _, t := range result.Trials {
+				e.Trials = append(e.Trials, jsonSearchTrial(t))
+			}
+			if result.Err != nil {
+				e.Error = result.Err.Error()
+			}
+			out.Summary.Search.Endpoints = append(out.Summary.Search.Endpoints, e)
+		}
+	}
+	out.MismatchGroups = make([]jsonMismatchGroup, 0, len(r.groups.groups))
+	for _, group := range r.groups.Sorted() {
+		g := jsonMismatchGroup{
+			Method:    group.Method,
+			Endpoints: group.Partition,
+			Paths:     group.Paths,
+			Count:     group.Count,
+		}
+		if req := group.Example[0].Request; req != nil {
+			g.Example.Request = string(req.Line)
+		}
+		g.Example.Diff = group.Example.Diff(r.comparator(), diffFormat{})
+		out.MismatchGroups = append(out.MismatchGroups, g)
+	}
+	for i, c := range r.Clients {
+		endpoint := c.Stats.JSON()
+		endpoint.Index = i
+		endpoint.Name = c.Name
+		endpoint.Endpoint = c.Endpoint
+		out.Endpoints = append(out.Endpoints, endpoint)
+	}
+	return out
+}
+
+// RenderJSON writes the report in the versioned JSON schema.
+func (r *report) RenderJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.JSON())
+}
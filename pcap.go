@@ -0,0 +1,766 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Link types of captured packets, see https://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull      = 0
+	linkTypeEthernet  = 1
+	linkTypeRaw       = 101
+	linkTypeLoop      = 108
+	linkTypeLinuxSLL  = 113
+	linkTypeIPv4      = 228
+	linkTypeIPv6      = 229
+	linkTypeLinuxSLL2 = 276
+)
+
+const (
+	// maxOutOfOrder is the most bytes buffered for out-of-order TCP segments
+	// per stream, after which the stream is abandoned.
+	maxOutOfOrder = 16 * 1024 * 1024
+	// maxCaptureBlock is the largest packet or block accepted in a capture.
+	maxCaptureBlock = 16 * 1024 * 1024
+	// maxStreamIdle is how long a TCP stream can go without packets, by the
+	// capture time, before it's abandoned.
+	maxStreamIdle = 5 * time.Minute
+)
+
+var errUnsupportedCapture = errors.New("unsupported capture format, must be pcap or pcapng")
+
+// packetReader reads packets from a capture file.
+type packetReader interface {
+	// ReadPacket returns the next packet, its link type and when it was
+	// captured, which is zero if the capture doesn't say.
+	ReadPacket() ([]byte, uint32, time.Time, error)
+}
+
+// newPacketReader detects whether the capture is pcap or pcapng.
+func newPacketReader(r *bufio.Reader) (packetReader, error) {
+	magic, err := r.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(magic, []byte{0x0a, 0x0d, 0x0d, 0x0a}):
+		return &pcapngReader{r: r}, nil
+	case binary.LittleEndian.Uint32(magic) == 0xa1b2c3d4, binary.LittleEndian.Uint32(magic) == 0xa1b23c4d:
+		return newPcapReader(r, binary.LittleEndian)
+	case binary.BigEndian.Uint32(magic) == 0xa1b2c3d4, binary.BigEndian.Uint32(magic) == 0xa1b23c4d:
+		return newPcapReader(r, binary.BigEndian)
+	}
+	return nil, errUnsupportedCapture
+}
+
+// pcapReader reads the classic libpcap format.
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	linkType uint32
+	nano     bool // Timestamps in nanoseconds rather than microseconds
+	header   [16]byte
+}
+
+func newPcapReader(r io.Reader, order binary.ByteOrder) (*pcapReader, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	return &pcapReader{
+		r:        r,
+		order:    order,
+		linkType: order.Uint32(header[20:24]),
+		nano:     order.Uint32(header[0:4]) == 0xa1b23c4d,
+	}, nil
+}
+
+func (p *pcapReader) ReadPacket() ([]byte, uint32, time.Time, error) {
+	if _, err := io.ReadFull(p.r, p.header[:]); err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	length := p.order.Uint32(p.header[8:12])
+	if length > maxCaptureBlock {
+		return nil, 0, time.Time{}, fmt.Errorf("invalid pcap packet length: %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return nil, 0, time.Time{}, unexpectedEOF(err)
+	}
+	frac := int64(p.order.Uint32(p.header[4:8]))
+	if !p.nano {
+		frac *= int64(time.Microsecond)
+	}
+	return data, p.linkType, time.Unix(int64(p.order.Uint32(p.header[0:4])), frac), nil
+}
+
+// pcapngReader reads the pcapng format, which can have multiple sections and
+// interfaces with different link types.
+type pcapngReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []pcapngInterface // Of the current section
+}
+
+type pcapngInterface struct {
+	linkType uint32
+	units    uint64 // Timestamp units per second, 0 if unsupported
+}
+
+// interfaceUnits returns the timestamp units per second of an interface from
+// its if_tsresol option, microseconds by default.
+func interfaceUnits(order binary.ByteOrder, options []byte) uint64 {
+	for len(options) >= 4 {
+		code := order.Uint16(options[0:2])
+		length := int(order.Uint16(options[2:4]))
+		options = options[4:]
+		if code == 0 || length > len(options) {
+			break
+		}
+		if code == 9 && length >= 1 {
+			resol := options[0]
+			if resol&0x80 != 0 {
+				if resol&0x7f > 30 {
+					return 0
+				}
+				return 1 << (resol & 0x7f)
+			}
+			if resol > 9 {
+				return 0
+			}
+			units := uint64(1)
+			for i := byte(0); i < resol; i++ {
+				units *= 10
+			}
+			return units
+		}
+		// Options are padded to 32 bits
+		if padded := (length + 3) &^ 3; padded < len(options) {
+			options = options[padded:]
+		} else {
+			break
+		}
+	}
+	return uint64(time.Second / time.Microsecond)
+}
+
+func (p *pcapngReader) ReadPacket() ([]byte, uint32, time.Time, error) {
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(p.r, header[:]); err != nil {
+			return nil, 0, time.Time{}, err
+		}
+
+		blockType := binary.LittleEndian.Uint32(header[0:4])
+		if blockType == 0x0a0d0d0a {
+			// Section header, which determines the byte order of the section
+			var magic [4]byte
+			if _, err := io.ReadFull(p.r, magic[:]); err != nil {
+				return nil, 0, time.Time{}, unexpectedEOF(err)
+			}
+			switch {
+			case binary.LittleEndian.Uint32(magic[:]) == 0x1a2b3c4d:
+				p.order = binary.LittleEndian
+			case binary.BigEndian.Uint32(magic[:]) == 0x1a2b3c4d:
+				p.order = binary.BigEndian
+			default:
+				return nil, 0, time.Time{}, errUnsupportedCapture
+			}
+			p.interfaces = nil
+			length := p.order.Uint32(header[4:8])
+			if length < 12 {
+				return nil, 0, time.Time{}, fmt.Errorf("invalid pcapng block length: %d", length)
+			}
+			if _, err := io.CopyN(ioutil.Discard, p.r, int64(length-12)); err != nil {
+				return nil, 0, time.Time{}, unexpectedEOF(err)
+			}
+			continue
+		}
+		if p.order == nil {
+			return nil, 0, time.Time{}, errUnsupportedCapture
+		}
+
+		blockType = p.order.Uint32(header[0:4])
+		length := p.order.Uint32(header[4:8])
+		if length < 12 || length > maxCaptureBlock {
+			return nil, 0, time.Time{}, fmt.Errorf("invalid pcapng block length: %d", length)
+		}
+		body := make([]byte, length-8)
+		if _, err := io.ReadFull(p.r, body); err != nil {
+			return nil, 0, time.Time{}, unexpectedEOF(err)
+		}
+		body = body[:len(body)-4] // Trailing block length
+
+		switch blockType {
+		case 1: // Interface description
+			if len(body) < 2 {
+				return nil, 0, time.Time{}, errors.New("invalid pcapng interface block")
+			}
+			iface := pcapngInterface{linkType: uint32(p.order.Uint16(body[0:2]))}
+			if len(body) >= 8 {
+				iface.units = interfaceUnits(p.order, body[8:])
+			}
+			p.interfaces = append(p.interfaces, iface)
+		case 6: // Enhanced packet
+			if len(body) < 20 {
+				return nil, 0, time.Time{}, errors.New("invalid pcapng packet block")
+			}
+			iface := p.order.Uint32(body[0:4])
+			length := p.order.Uint32(body[12:16])
+			if int(iface) >= len(p.interfaces) || int(length) > len(body)-20 {
+				return nil, 0, time.Time{}, errors.New("invalid pcapng packet block")
+			}
+			var at time.Time
+			if units := p.interfaces[iface].units; units > 0 {
+				ts := uint64(p.order.Uint32(body[4:8]))<<32 | uint64(p.order.Uint32(body[8:12]))
+				at = time.Unix(int64(ts/units), int64(ts%units*uint64(time.Second)/units))
+			}
+			return body[20 : 20+length], p.interfaces[iface].linkType, at, nil
+		case 3: // Simple packet, always on the first interface
+			if len(body) < 4 || len(p.interfaces) == 0 {
+				return nil, 0, time.Time{}, errors.New("invalid pcapng packet block")
+			}
+			length := p.order.Uint32(body[0:4])
+			if int(length) > len(body)-4 {
+				length = uint32(len(body) - 4)
+			}
+			// Simple packets aren't timestamped
+			return body[4 : 4+length], p.interfaces[0].linkType, time.Time{}, nil
+		}
+		// Skip other blocks (statistics, name resolution, etc.)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// decodeLink strips the link layer of a packet, returning the IP packet.
+func decodeLink(linkType uint32, data []byte) ([]byte, bool) {
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType, data := binary.BigEndian.Uint16(data[12:14]), data[14:]
+		for etherType == 0x8100 || etherType == 0x88a8 { // VLAN tags
+			if len(data) < 4 {
+				return nil, false
+			}
+			etherType, data = binary.BigEndian.Uint16(data[2:4]), data[4:]
+		}
+		return data, etherType == 0x0800 || etherType == 0x86dd
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return nil, false
+		}
+		return data[4:], true
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		return data[16:], true
+	case linkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return nil, false
+		}
+		return data[20:], true
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+		return data, true
+	}
+	return nil, false
+}
+
+// flowKey identifies one direction of a TCP connection.
+type flowKey struct {
+	src, dst         string // IP addresses as raw bytes
+	srcPort, dstPort uint16
+}
+
+// reverse returns the other direction of the connection.
+func (k flowKey) reverse() flowKey {
+	return flowKey{src: k.dst, dst: k.src, srcPort: k.dstPort, dstPort: k.srcPort}
+}
+
+type tcpSegment struct {
+	flow    flowKey
+	seq     uint32
+	syn     bool
+	fin     bool
+	rst     bool
+	payload []byte
+}
+
+// decodeTCP decodes an IPv4 or IPv6 packet carrying a TCP segment.
+func decodeTCP(data []byte) (tcpSegment, bool) {
+	var seg tcpSegment
+	if len(data) < 1 {
+		return seg, false
+	}
+
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return seg, false
+		}
+		headerLen := int(data[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(data[2:4]))
+		if totalLen == 0 {
+			// Segmentation offload, the length is unknown when captured
+			totalLen = len(data)
+		}
+		if data[9] != 6 || headerLen < 20 || totalLen < headerLen || totalLen > len(data) {
+			return seg, false
+		}
+		if binary.BigEndian.Uint16(data[6:8])&0x3fff != 0 {
+			// Fragmented, not supported
+			return seg, false
+		}
+		seg.flow.src, seg.flow.dst = string(data[12:16]), string(data[16:20])
+		data = data[headerLen:totalLen]
+	case 6:
+		if len(data) < 40 {
+			return seg, false
+		}
+		payloadLen := int(binary.BigEndian.Uint16(data[4:6]))
+		if data[6] != 6 || 40+payloadLen > len(data) {
+			// Extension headers are not supported
+			return seg, false
+		}
+		seg.flow.src, seg.flow.dst = string(data[8:24]), string(data[24:40])
+		data = data[40 : 40+payloadLen]
+	default:
+		return seg, false
+	}
+
+	if len(data) < 20 {
+		return seg, false
+	}
+	offset := int(data[12]>>4) * 4
+	if offset < 20 || offset > len(data) {
+		return seg, false
+	}
+	flags := data[13]
+	seg.flow.srcPort = binary.BigEndian.Uint16(data[0:2])
+	seg.flow.dstPort = binary.BigEndian.Uint16(data[2:4])
+	seg.seq = binary.BigEndian.Uint32(data[4:8])
+	seg.syn = flags&0x02 != 0
+	seg.fin = flags&0x01 != 0
+	seg.rst = flags&0x04 != 0
+	seg.payload = data[offset:]
+	return seg, true
+}
+
+type streamState int
+
+const (
+	streamUnknown   streamState = iota // Nothing parsed yet
+	streamHTTP                         // Parsing HTTP requests
+	streamWebsocket                    // Parsing websocket frames from the client
+	streamIgnored                      // Not a client stream, or failed to parse
+)
+
+// tcpStream reassembles one direction of a TCP connection, and parses the
+// requests sent on it.
+type tcpStream struct {
+	started bool
+	last    time.Time         // Capture time of the last segment
+	next    uint32            // Next expected sequence number
+	buf     []byte            // Reassembled data that hasn't been parsed yet
+	pending map[uint32][]byte // Out-of-order segments
+	size    int               // Bytes in pending
+
+	state   streamState
+	message []byte // Fragmented websocket message
+	skip    bool   // Skip the fragmented websocket message
+}
+
+// add adds a segment to the stream, and returns false if the stream should be
+// abandoned.
+func (st *tcpStream) add(seg tcpSegment) bool {
+	if seg.syn {
+		st.started = true
+		st.next = seg.seq + 1
+		return true
+	}
+	if !st.started {
+		// Capture started mid-stream
+		st.started = true
+		st.next = seg.seq
+	}
+
+	payload := seg.payload
+	if len(payload) == 0 {
+		return true
+	}
+	if int32(seg.seq-st.next) > 0 {
+		if st.pending == nil {
+			st.pending = map[uint32][]byte{}
+		}
+		if _, ok := st.pending[seg.seq]; !ok {
+			st.pending[seg.seq] = append([]byte(nil), payload...)
+			st.size += len(payload)
+		}
+		return st.size <= maxOutOfOrder
+	}
+	st.append(seg.seq, payload)
+
+	// Fill in any out-of-order segments that are now contiguous
+	for progress := true; progress; {
+		progress = false
+		for seq, payload := range st.pending {
+			if int32(seq-st.next) > 0 {
+				continue
+			}
+			delete(st.pending, seq)
+			st.size -= len(payload)
+			st.append(seq, payload)
+			progress = true
+		}
+	}
+	return true
+}
+
+// append adds payload starting at seq to the stream, skipping any data that
+// was already received.
+func (st *tcpStream) append(seq uint32, payload []byte) {
+	overlap := int(st.next - seq)
+	if overlap >= len(payload) {
+		return
+	}
+	payload = payload[overlap:]
+	st.next += uint32(len(payload))
+
+	if st.state == streamIgnored {
+		// Resynchronize if a new request starts at this segment
+		if overlap > 0 || len(st.buf) > 0 || !isHTTPRequest(payload) {
+			return
+		}
+		st.state = streamUnknown
+	}
+	st.buf = append(st.buf, payload...)
+}
+
+var httpMethods = []string{"GET ", "POST ", "PUT ", "PATCH ", "DELETE ", "OPTIONS ", "HEAD "}
+
+func isHTTPRequest(b []byte) bool {
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(b, []byte(method)) {
+			return true
+		}
+	}
+	return false
+}
+
+// mightBeHTTPRequest returns true if b is too short to tell whether it's the
+// beginning of a request.
+func mightBeHTTPRequest(b []byte) bool {
+	for _, method := range httpMethods {
+		if len(b) < len(method) && strings.HasPrefix(method, string(b)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parse returns the request bodies that are complete in the stream.
+func (st *tcpStream) parse() [][]byte {
+	var bodies [][]byte
+	for len(st.buf) > 0 {
+		switch st.state {
+		case streamIgnored:
+			st.buf = nil
+		case streamUnknown, streamHTTP:
+			if !isHTTPRequest(st.buf) {
+				if !mightBeHTTPRequest(st.buf) {
+					st.state = streamIgnored
+				}
+				return bodies
+			}
+			body, upgrade, n, err := readHTTPRequest(st.buf)
+			if err == io.ErrUnexpectedEOF {
+				// Wait for more
+				return bodies
+			}
+			if err != nil {
+				logger.Debug().Err(err).Msg("failed to parse captured http request")
+				st.state = streamIgnored
+				continue
+			}
+			st.buf = st.buf[n:]
+			st.state = streamHTTP
+			if upgrade {
+				st.state = streamWebsocket
+			}
+			if len(body) > 0 {
+				bodies = append(bodies, body)
+			}
+		case streamWebsocket:
+			frame, ok := readWebsocketFrame(st.buf)
+			if !ok {
+				return bodies
+			}
+			st.buf = st.buf[frame.n:]
+			if body, ok := st.frame(frame); ok {
+				bodies = append(bodies, body)
+			}
+		}
+	}
+	// Avoid holding onto the consumed data
+	st.buf = nil
+	return bodies
+}
+
+// readHTTPRequest reads a request from the start of b, returning its body,
+// whether it's upgrading to a websocket, and the length of the request.
+func readHTTPRequest(b []byte) ([]byte, bool, int, error) {
+	r := bytes.NewReader(b)
+	br := bufio.NewReader(r)
+	req, err := http.ReadRequest(br)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, false, 0, err
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, false, 0, err
+	}
+	upgrade := strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+	return body, upgrade, len(b) - r.Len() - br.Buffered(), nil
+}
+
+type websocketFrame struct {
+	fin        bool
+	compressed bool
+	opcode     byte
+	payload    []byte
+	n          int // Length of the frame
+}
+
+// readWebsocketFrame reads a frame from the start of b, returning false if
+// the frame is incomplete.
+func readWebsocketFrame(b []byte) (websocketFrame, bool) {
+	var frame websocketFrame
+	if len(b) < 2 {
+		return frame, false
+	}
+	frame.fin = b[0]&0x80 != 0
+	frame.compressed = b[0]&0x40 != 0
+	frame.opcode = b[0] & 0x0f
+	masked := b[1]&0x80 != 0
+
+	n := 2
+	length := uint64(b[1] & 0x7f)
+	switch length {
+	case 126:
+		if len(b) < n+2 {
+			return frame, false
+		}
+		length = uint64(binary.BigEndian.Uint16(b[n:]))
+		n += 2
+	case 127:
+		if len(b) < n+8 {
+			return frame, false
+		}
+		length = binary.BigEndian.Uint64(b[n:])
+		n += 8
+	}
+	var mask []byte
+	if masked {
+		if len(b) < n+4 {
+			return frame, false
+		}
+		mask = b[n : n+4]
+		n += 4
+	}
+	if uint64(len(b)-n) < length {
+		return frame, false
+	}
+
+	frame.payload = make([]byte, length)
+	copy(frame.payload, b[n:])
+	for i := range mask {
+		for j := i; j < len(frame.payload); j += 4 {
+			frame.payload[j] ^= mask[i]
+		}
+	}
+	frame.n = n + int(length)
+	return frame, true
+}
+
+// frame assembles websocket messages from their frames, returning complete
+// messages.
+func (st *tcpStream) frame(frame websocketFrame) ([]byte, bool) {
+	switch frame.opcode {
+	case 0x1, 0x2: // Text, binary
+		st.message = frame.payload
+		st.skip = frame.compressed
+	case 0x0: // Continuation
+		st.message = append(st.message, frame.payload...)
+	default: // Control frames
+		return nil, false
+	}
+	if !frame.fin {
+		return nil, false
+	}
+
+	message := st.message
+	st.message = nil
+	if st.skip {
+		logger.Debug().Msg("skipping compressed captured websocket message")
+		return nil, false
+	}
+	return message, true
+}
+
+// pcapScanner extracts JSON-RPC request bodies from the HTTP and websocket
+// traffic in a pcap or pcapng capture, like from `tcpdump -w -`. It's a
+// drop-in replacement for a bufio.Scanner of lines.
+type pcapScanner struct {
+	r       *bufio.Reader
+	packets packetReader
+	streams map[flowKey]*tcpStream
+	now     time.Time // Capture time of the latest packet
+	evicted time.Time // When idle streams were last evicted
+
+	queue   [][]byte
+	current []byte
+	err     error
+	done    bool
+}
+
+func newPcapScanner(r io.Reader) *pcapScanner {
+	return &pcapScanner{
+		r:       bufio.NewReader(r),
+		streams: map[flowKey]*tcpStream{},
+	}
+}
+
+func (s *pcapScanner) Scan() bool {
+	for len(s.queue) == 0 {
+		if s.done {
+			return false
+		}
+		if err := s.next(); err != nil {
+			s.done = true
+			if err != io.EOF {
+				s.err = err
+			}
+			return false
+		}
+	}
+	s.current, s.queue = s.queue[0], s.queue[1:]
+	return true
+}
+
+// next reads the next packet and queues any requests it completes.
+func (s *pcapScanner) next() error {
+	if s.packets == nil {
+		packets, err := newPacketReader(s.r)
+		if err != nil {
+			return err
+		}
+		s.packets = packets
+	}
+
+	data, linkType, at, err := s.packets.ReadPacket()
+	if err != nil {
+		return err
+	}
+	if at.After(s.now) {
+		s.now = at
+		s.evictIdle()
+	}
+	network, ok := decodeLink(linkType, data)
+	if !ok {
+		return nil
+	}
+	seg, ok := decodeTCP(network)
+	if !ok {
+		return nil
+	}
+	if seg.rst {
+		// The connection is gone, in both directions
+		delete(s.streams, seg.flow)
+		delete(s.streams, seg.flow.reverse())
+		return nil
+	}
+
+	st, ok := s.streams[seg.flow]
+	if !ok {
+		if len(seg.payload) == 0 && !seg.syn {
+			return nil
+		}
+		st = &tcpStream{}
+		s.streams[seg.flow] = st
+	}
+	st.last = s.now
+	if !st.add(seg) {
+		logger.Debug().Msg("abandoning captured tcp stream with too many out-of-order segments")
+		delete(s.streams, seg.flow)
+		return nil
+	}
+	for _, body := range st.parse() {
+		if line, ok := jsonLine(body); ok {
+			s.queue = append(s.queue, line)
+		}
+	}
+	if seg.fin {
+		delete(s.streams, seg.flow)
+	}
+	return nil
+}
+
+// evictIdle abandons the streams without packets for maxStreamIdle, like
+// connections whose end wasn't captured. Checked at most every maxStreamIdle.
+func (s *pcapScanner) evictIdle() {
+	if s.now.Sub(s.evicted) < maxStreamIdle {
+		return
+	}
+	s.evicted = s.now
+	for flow, st := range s.streams {
+		if s.now.Sub(st.last) >= maxStreamIdle {
+			delete(s.streams, flow)
+		}
+	}
+}
+
+// jsonLine compacts a JSON request body onto a single line, returning false if
+// it's not a JSON object or array.
+func jsonLine(body []byte) ([]byte, bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || (body[0] != '{' && body[0] != '[') {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, body); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+func (s *pcapScanner) Bytes() []byte {
+	return s.current
+}
+
+func (s *pcapScanner) Err() error {
+	return s.err
+}
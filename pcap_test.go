@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// tcpPacket builds an ethernet frame with an IPv4 TCP segment.
+func tcpPacket(srcPort, dstPort uint16, seq uint32, flags byte, payload []byte) []byte {
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:8], seq)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	tcp = append(tcp, payload...)
+
+	ip := make([]byte, 20, 20+len(tcp))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(tcp)))
+	ip[9] = 6
+	copy(ip[12:16], []byte{10, 0, 0, 1})
+	copy(ip[16:20], []byte{10, 0, 0, 2})
+	ip = append(ip, tcp...)
+
+	eth := make([]byte, 14, 14+len(ip))
+	binary.BigEndian.PutUint16(eth[12:14], 0x0800)
+	return append(eth, ip...)
+}
+
+// maskedFrame builds a websocket frame as sent by a client.
+func maskedFrame(fin bool, opcode byte, payload string) []byte {
+	b := []byte{opcode, 0x80 | byte(len(payload))}
+	if fin {
+		b[0] |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	b = append(b, mask...)
+	for i := range payload {
+		b = append(b, payload[i]^mask[i%4])
+	}
+	return b
+}
+
+// reversed swaps the IP addresses of a packet built by tcpPacket, for the
+// other direction of the connection.
+func reversed(packet []byte) []byte {
+	p := append([]byte(nil), packet...)
+	copy(p[26:30], packet[30:34])
+	copy(p[30:34], packet[26:30])
+	return p
+}
+
+// writePcap writes the packets to a pcap capture, captured at the times if
+// they're set.
+func writePcap(packets [][]byte, times []time.Time) []byte {
+	var buf bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], linkTypeEthernet)
+	buf.Write(header)
+	for i, p := range packets {
+		record := make([]byte, 16)
+		if times != nil {
+			binary.LittleEndian.PutUint32(record[0:4], uint32(times[i].Unix()))
+			binary.LittleEndian.PutUint32(record[4:8], uint32(times[i].Nanosecond()/1000))
+		}
+		binary.LittleEndian.PutUint32(record[8:12], uint32(len(p)))
+		binary.LittleEndian.PutUint32(record[12:16], uint32(len(p)))
+		buf.Write(record)
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
+
+func writePcapng(packets [][]byte, times []time.Time) []byte {
+	var buf bytes.Buffer
+	block := func(blockType uint32, body []byte) {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		b := make([]byte, 8, 12+len(body))
+		binary.BigEndian.PutUint32(b[0:4], blockType)
+		binary.BigEndian.PutUint32(b[4:8], uint32(12+len(body)))
+		b = append(b, body...)
+		b = append(b, b[4:8]...)
+		buf.Write(b)
+	}
+	// Big-endian section, to exercise byte order detection
+	block(0x0a0d0d0a, []byte{0x1a, 0x2b, 0x3c, 0x4d, 0, 1, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	block(1, []byte{0, linkTypeEthernet, 0, 0, 0, 0, 0xff, 0xff})
+	for i, p := range packets {
+		body := make([]byte, 20, 20+len(p))
+		if times != nil {
+			// Microseconds, the default resolution
+			ts := uint64(times[i].UnixNano() / 1000)
+			binary.BigEndian.PutUint32(body[4:8], uint32(ts>>32))
+			binary.BigEndian.PutUint32(body[8:12], uint32(ts))
+		}
+		binary.BigEndian.PutUint32(body[12:16], uint32(len(p)))
+		binary.BigEndian.PutUint32(body[16:20], uint32(len(p)))
+		block(6, append(body, p...))
+	}
+	return buf.Bytes()
+}
+
+func TestPcapScanner(t *testing.T) {
+	const (
+		syn = 0x02
+		ack = 0x10
+		fin = 0x01
+	)
+	post := func(body string) []byte {
+		return []byte(fmt.Sprintf("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+	}
+	first := post(`{"jsonrpc": "2.0", "id": 1, "method": "eth_blockNumber"}`)
+	second := post(`{"jsonrpc":"2.0","id":3,"method":"eth_chainId"}`)
+	upgrade := []byte("GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	message := `{"jsonrpc":"2.0","id":2,"method":"eth_gasPrice"}`
+
+	var frames []byte
+	frames = append(frames, maskedFrame(false, 0x1, message[:10])...)
+	frames = append(frames, maskedFrame(true, 0x9, "ping")...)
+	frames = append(frames, maskedFrame(true, 0x0, message[10:])...)
+
+	packets := [][]byte{
+		tcpPacket(5000, 8545, 100, syn, nil),
+		// Out of order, with a retransmission
+		tcpPacket(5000, 8545, 131, ack, first[30:]),
+		tcpPacket(5000, 8545, 101, ack, first[:30]),
+		tcpPacket(5000, 8545, 101, ack, first[:30]),
+		tcpPacket(8545, 5000, 500, ack, []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n{}")),
+		// Websocket stream without a handshake in the capture
+		tcpPacket(5001, 8546, 1000, ack, upgrade),
+		tcpPacket(5001, 8546, 1000+uint32(len(upgrade)), ack, frames[:7]),
+		tcpPacket(5001, 8546, 1000+uint32(len(upgrade)+7), ack, frames[7:]),
+		// Keep-alive
+		tcpPacket(5000, 8545, 101+uint32(len(first)), ack|fin, second),
+	}
+
+	want := []string{
+		`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`,
+		message,
+		`{"jsonrpc":"2.0","id":3,"method":"eth_chainId"}`,
+	}
+
+	for name, capture := range map[string][]byte{
+		"pcap":   writePcap(packets, nil),
+		"pcapng": writePcapng(packets, nil),
+	} {
+		s := newPcapScanner(bytes.NewReader(capture))
+		var got []string
+		for s.Scan() {
+			got = append(got, string(s.Bytes()))
+		}
+		if err := s.Err(); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got: %q; want: %q", name, got, want)
+		}
+	}
+}
+
+func TestPcapScannerEvicts(t *testing.T) {
+	const (
+		ack = 0x10
+		rst = 0x04
+	)
+	partial := []byte("POST / HTTP/1.1\r\nHost: localhost\r\n")
+	packets := [][]byte{
+		// Client stream which never finishes its request
+		tcpPacket(5000, 8545, 100, ack, partial),
+		// Server stream which is reset by the client
+		tcpPacket(8546, 5001, 500, ack, []byte("HTTP/1.1 200 OK\r\n")),
+		reversed(tcpPacket(5001, 8546, 100, rst, nil)),
+		// Once the first stream has been idle for too long
+		tcpPacket(5002, 8545, 100, ack, partial),
+	}
+	started := time.Unix(1600000000, 0)
+	times := []time.Time{started, started.Add(time.Second), started.Add(2 * time.Second), started.Add(maxStreamIdle + time.Second)}
+
+	for name, capture := range map[string][]byte{
+		"pcap":   writePcap(packets, times),
+		"pcapng": writePcapng(packets, times),
+	} {
+		s := newPcapScanner(bytes.NewReader(capture))
+		for s.Scan() {
+		}
+		if err := s.Err(); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if len(s.streams) != 1 {
+			t.Errorf("%s: got: %d streams; want only the last one", name, len(s.streams))
+		}
+		for flow := range s.streams {
+			if flow.srcPort != 5002 {
+				t.Errorf("%s: got: stream from port %d; want: 5002", name, flow.srcPort)
+			}
+		}
+	}
+}
+
+func TestInterfaceUnits(t *testing.T) {
+	tests := []struct {
+		options []byte
+		want    uint64
+	}{
+		{nil, 1000000},
+		{[]byte{0, 9, 0, 1, 9, 0, 0, 0, 0, 0, 0, 0}, 1000000000},
+		{[]byte{0, 9, 0, 1, 0x80 | 10, 0, 0, 0}, 1024},
+		// Skips other options, like the interface name
+		{[]byte{0, 2, 0, 3, 'e', 't', 'h', 0, 0, 9, 0, 1, 3, 0, 0, 0}, 1000},
+		{[]byte{0, 9, 0, 1, 12, 0, 0, 0}, 0},
+	}
+
+	for _, tc := range tests {
+		if got := interfaceUnits(binary.BigEndian, tc.options); got != tc.want {
+			t.Errorf("%v: got: %d; want: %d", tc.options, got, tc.want)
+		}
+	}
+}
+
+func TestPcapScannerInvalid(t *testing.T) {
+	s := newPcapScanner(bytes.NewReader([]byte(`{"id":1}`)))
+	if s.Scan() {
+		t.Error("unexpected scan")
+	}
+	if s.Err() != errUnsupportedCapture {
+		t.Errorf("got: %v; want: %v", s.Err(), errUnsupportedCapture)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var errPipelineTimeout = errors.New("pipelined request timed out")
+
+type pipelineResult struct {
+	fields map[string]json.RawMessage
+	err    error
+}
+
+// websocketPipeline multiplexes many in-flight JSON-RPC requests over a single
+// websocket connection. Request ids are rewritten to be unique on the
+// connection, and restored on the responses.
+type websocketPipeline struct {
+	endpoint string
+	header   http.Header
+	timeout  time.Duration
+
+	mu      sync.Mutex // Protects the fields below, and writes to ws
+	ws      *websocket.Conn
+	nextID  uint64
+	pending map[string]chan pipelineResult
+}
+
+// start launches the reader for the current connection.
+func (p *websocketPipeline) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending == nil {
+		p.pending = map[string]chan pipelineResult{}
+	}
+	if p.ws != nil {
+		go p.read(p.ws)
+	}
+}
+
+// conn returns the current connection, redialing if the last one failed. Must
+// be called with the lock held.
+func (p *websocketPipeline) conn() (*websocket.Conn, error) {
+	if p.ws != nil {
+		return p.ws, nil
+	}
+	conn, err := dialWebsocket(p.endpoint, p.header)
+	if err != nil {
+		return nil, err
+	}
+	p.ws = conn
+	go p.read(conn)
+	return conn, nil
+}
+
+// read dispatches messages from conn to their pending requests until the
+// connection fails, at which point all pending requests fail with it.
+func (p *websocketPipeline) read(conn *websocket.Conn) {
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			p.mu.Lock()
+			if p.ws == conn {
+				p.ws = nil
+			}
+			pending := p.pending
+			p.pending = map[string]chan pipelineResult{}
+			p.mu.Unlock()
+
+			conn.Close()
+			for _, ch := range pending {
+				ch <- pipelineResult{err: err}
+			}
+			return
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(message, &fields); err != nil {
+			logger.Debug().Str("endpoint", p.endpoint).Err(err).Msg("skipping invalid pipelined websocket message")
+			continue
+		}
+
+		key := string(fields["id"])
+		p.mu.Lock()
+		ch, ok := p.pending[key]
+		delete(p.pending, key)
+		p.mu.Unlock()
+
+		if !ok {
+			logger.Debug().Str("endpoint", p.endpoint).Str("id", key).Msg("skipping pipelined websocket message with unexpected id")
+			continue
+		}
+		ch <- pipelineResult{fields: fields}
+	}
+}
+
+// Close closes the current connection, which stops its reader and fails the
+// pending requests.
+func (p *websocketPipeline) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ws == nil {
+		return nil
+	}
+	err := p.ws.Close()
+	p.ws = nil
+	return err
+}
+
+// Send is safe to call concurrently. It blocks until the response for body
+// arrives, or the timeout is reached.
+func (p *websocketPipeline) Send(body []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, errors.New("pipelined requests must be JSON-RPC objects")
+	}
+	originalID, hasID := fields["id"]
+
+	ch := make(chan pipelineResult, 1)
+
+	p.mu.Lock()
+	p.nextID += 1
+	key := strconv.FormatUint(p.nextID, 10)
+	fields["id"] = json.RawMessage(key)
+	msg, err := json.Marshal(fields)
+	if err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+	conn, err := p.conn()
+	if err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+	p.pending[key] = ch
+	if p.timeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(p.timeout))
+	}
+	err = conn.WriteMessage(websocket.TextMessage, msg)
+	if err != nil {
+		delete(p.pending, key)
+		// Unblock the reader, which fails the remaining pending requests
+		conn.Close()
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var timeout <-chan time.Time
+	if p.timeout > 0 {
+		timer := time.NewTimer(p.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var result pipelineResult
+	select {
+	case result = <-ch:
+	case <-timeout:
+		p.mu.Lock()
+		delete(p.pending, key)
+		p.mu.Unlock()
+		return nil, errPipelineTimeout
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	if hasID {
+		result.fields["id"] = originalID
+	} else {
+		delete(result.fields, "id")
+	}
+	return json.Marshal(result.fields)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxConcurrentRechecks bounds the number of mismatches being rechecked at
+// once, further rechecks wait their turn.
+const maxConcurrentRechecks = 16
+
+// recheckResult is the outcome of rechecking a mismatched response set.
+type recheckResult struct {
+	Responses []Response // Original mismatched responses
+	Transient bool       // Responses matched on a recheck
+	Attempts  int
+}
+
+// rechecker re-sends mismatched requests to every endpoint, to tell transient
+// mismatches (like endpoints at different chain heads) from persistent ones.
+// Rechecks use their own transports, so they're not counted in the stats.
+type rechecker struct {
+	Retries    int
+	Delay      time.Duration
+	Comparator *comparator
+
+	clients    map[*Client]int
+	transports []Transport
+	locks      []sync.Mutex // For transports which can't be shared
+	sem        chan struct{}
+	results    chan recheckResult
+}
+
+func newRechecker(clients Clients, retries int, delay time.Duration) (*rechecker, error) {
+	rc := &rechecker{
+		Retries:    retries,
+		Delay:      delay,
+		clients:    make(map[*Client]int, len(clients)),
+		transports: make([]Transport, len(clients)),
+		locks:      make([]sync.Mutex, len(clients)),
+		sem:        make(chan struct{}, maxConcurrentRechecks),
+		results:    make(chan recheckResult),
+	}
+	for i, c := range clients {
+		t, err := NewTransport(c.Endpoint, c.Timeout, c.Header)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		rc.clients[c] = i
+		rc.transports[i] = t
+	}
+	return rc, nil
+}
+
+// Close closes the transports of the rechecker, rechecks which are still
+// running fail.
+func (rc *rechecker) Close() {
+	for i, t := range rc.transports {
+		if t == nil {
+			continue
+		}
+		rc.locks[i].Lock()
+		closeTransport(t)
+		rc.locks[i].Unlock()
+	}
+}
+
+// Results returns the channel of recheck outcomes.
+func (rc *rechecker) Results() <-chan recheckResult {
+	return rc.results
+}
+
+// Check rechecks the responses in the background, until they match or the
+// retries run out. The outcome is sent on Results, unless ctx is done first.
+func (rc *rechecker) Check(ctx context.Context, resps []Response) {
+	go func() {
+		result := recheckResult{Responses: resps}
+		for result.Attempts < rc.Retries && !result.Transient {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(rc.Delay):
+			}
+			result.Attempts += 1
+			rechecked := rc.send(ctx, resps)
+			result.Transient = len(partitionResponses(rc.comparator(), rechecked)) == 1
+		}
+		logger.Debug().Int("id", int(resps[0].ID)).Bool("transient", result.Transient).Int("attempts", result.Attempts).Msg("rechecked mismatch")
+
+		select {
+		case rc.results <- result:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+func (rc *rechecker) comparator() *comparator {
+	if rc.Comparator == nil {
+		return defaultComparator
+	}
+	return rc.Comparator
+}
+
+// send re-sends the request of each response to its endpoint concurrently.
+func (rc *rechecker) send(ctx context.Context, resps []Response) []Response {
+	select {
+	case rc.sem <- struct{}{}:
+		defer func() { <-rc.sem }()
+	case <-ctx.Done():
+		return resps
+	}
+
+	rechecked := make([]Response, len(resps))
+	var wg sync.WaitGroup
+	for i, resp := range resps {
+		i, resp := i, resp
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := Request{
+				client:    resp.client,
+				ID:        resp.ID,
+				Line:      resp.Request.Line,
+				Method:    resp.Request.Method,
+				Timestamp: time.Now(),
+			}
+			j := rc.clients[resp.client]
+			t := rc.transports[j]
+			if !isShared(t) {
+				rc.locks[j].Lock()
+				defer rc.locks[j].Unlock()
+			}
+			rechecked[i] = req.Do(t)
+		}()
+	}
+	wg.Wait()
+	return rechecked
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecheck(t *testing.T) {
+	// The lagging endpoint catches up after one recheck of "head", but is
+	// always wrong for "broken"
+	var mu sync.Mutex
+	calls := map[string]int{}
+	handler := func(lagging bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			req := string(body)
+			mu.Lock()
+			calls[req] += 1
+			n := calls[req]
+			mu.Unlock()
+			if lagging && (req == "broken" || n <= 2) {
+				w.Write([]byte(`"stale"`))
+				return
+			}
+			w.Write([]byte(`"fresh"`))
+		}
+	}
+	good := httptest.NewServer(handler(false))
+	defer good.Close()
+	lagging := httptest.NewServer(handler(true))
+	defer lagging.Close()
+
+	clients, err := NewClients([]string{good.URL, lagging.URL}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := newRechecker(clients, 3, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := report{Clients: clients, Recheck: rc}
+	r.init()
+
+	var mismatched [][]Response
+	r.MismatchedResponse = func(resps []Response) {
+		mismatched = append(mismatched, resps)
+	}
+
+	respCh := make(chan Response, 10)
+	for id, line := range []string{"head", "broken"} {
+		req := &Request{ID: requestID(id), Line: []byte(line)}
+		respCh <- Response{client: clients[0], Request: req, ID: req.ID, Body: []byte(`"fresh"`)}
+		respCh <- Response{client: clients[1], Request: req, ID: req.ID, Body: []byte(`"stale"`)}
+	}
+	close(respCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Serve(ctx, respCh); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.transient != 1 || r.persistent != 1 || r.rechecking != 0 {
+		t.Errorf("got: %d transient, %d persistent, %d rechecking; want: 1, 1, 0", r.transient, r.persistent, r.rechecking)
+	}
+	if r.mismatched != 1 || len(mismatched) != 1 || string(mismatched[0][0].Request.Line) != "broken" {
+		t.Errorf("got %d mismatched: %v; want only broken", r.mismatched, mismatched)
+	}
+	if calls["broken"] != 6 {
+		t.Errorf("got %d calls for broken; want 6", calls["broken"])
+	}
+}
+
+func TestRecheckerClose(t *testing.T) {
+	srv, open := wsConnServer(t, echoResult(`"0x1"`))
+	defer srv.Close()
+
+	url := strings.TrimPrefix(srv.URL, "http")
+	clients, err := NewClients([]string{"ws" + url, "ws+pipeline" + url}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := newRechecker(clients, 1, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(open); got != 2 {
+		t.Errorf("got: %d open connections; want: 2", got)
+	}
+	rc.Close()
+	waitClosed(t, open)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// reference attributes mismatches to the endpoints whose responses differ from
+// the reference response.
+type reference struct {
+	Majority bool // Use the most common response as the reference
+	Index    int  // Endpoint with the reference response, unless Majority
+}
+
+// parseReference parses "majority" or an endpoint index between 0 and n.
+// Returns nil if s is empty.
+func parseReference(s string, n int) (*reference, error) {
+	switch s {
+	case "":
+		return nil, nil
+	case "majority":
+		return &reference{Majority: true}, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 || i >= n {
+		return nil, fmt.Errorf("invalid reference, must be \"majority\" or an endpoint number between 0 and %d: %s", n-1, s)
+	}
+	return &reference{Index: i}, nil
+}
+
+// Outliers returns the responses which differ from the reference response.
+// Returns false if there is no reference response, when the reference endpoint
+// has no response or there is no single most common response.
+func (ref *reference) Outliers(c *comparator, resps []Response, index func(Response) int) ([]Response, bool) {
+	classes := partitionResponses(c, resps)
+
+	agreed := -1
+	if ref.Majority {
+		tied := false
+		for i, class := range classes {
+			if agreed < 0 || len(class) > len(classes[agreed]) {
+				agreed, tied = i, false
+			} else if len(class) == len(classes[agreed]) {
+				tied = true
+			}
+		}
+		if tied {
+			return nil, false
+		}
+	} else {
+		for i, class := range classes {
+			for _, resp := range class {
+				if index(resp) == ref.Index {
+					agreed = i
+				}
+			}
+		}
+		if agreed < 0 {
+			return nil, false
+		}
+	}
+
+	var outliers []Response
+	for i, class := range classes {
+		if i != agreed {
+			outliers = append(outliers, class...)
+		}
+	}
+	return outliers, true
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseReference(t *testing.T) {
+	if ref, err := parseReference("", 3); ref != nil || err != nil {
+		t.Errorf("got: %+v %v; want: nil", ref, err)
+	}
+	if ref, err := parseReference("majority", 3); err != nil || !ref.Majority {
+		t.Errorf("got: %+v %v; want majority", ref, err)
+	}
+	if ref, err := parseReference("2", 3); err != nil || ref.Majority || ref.Index != 2 {
+		t.Errorf("got: %+v %v; want index 2", ref, err)
+	}
+	for _, s := range []string{"3", "-1", "first"} {
+		if _, err := parseReference(s, 3); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestReportReference(t *testing.T) {
+	tests := []struct {
+		reference *reference
+		bodies    [][3]string
+		diverged  [3]int
+		undecided int
+	}{
+		{
+			reference: &reference{Majority: true},
+			bodies:    [][3]string{{"a", "a", "b"}, {"a", "c", "a"}, {"a", "b", "c"}, {"a", "a", "a"}},
+			diverged:  [3]int{0, 1, 1},
+			undecided: 1,
+		},
+		{
+			reference: &reference{Index: 0},
+			bodies:    [][3]string{{"a", "a", "b"}, {"a", "c", "c"}},
+			diverged:  [3]int{0, 1, 2},
+		},
+		{
+			reference: nil,
+			bodies:    [][3]string{{"a", "a", "b"}},
+		},
+	}
+
+	for i, tc := range tests {
+		clients, err := NewClients([]string{"noop://foo", "noop://bar", "noop://baz"}, 1, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := report{Clients: clients, Reference: tc.reference}
+		r.init()
+
+		for id, bodies := range tc.bodies {
+			req := &Request{ID: requestID(id), Method: "eth_call"}
+			for j, body := range bodies {
+				r.handle(Response{client: clients[j], Request: req, ID: req.ID, Body: []byte(body)})
+			}
+		}
+
+		for j, c := range clients {
+			if got, want := c.Stats.numDiverged, tc.diverged[j]; got != want {
+				t.Errorf("%d: endpoint %d diverged: got: %d; want: %d", i, j, got, want)
+			}
+		}
+		if got, want := r.undecided, tc.undecided; got != want {
+			t.Errorf("%d: undecided: got: %d; want: %d", i, got, want)
+		}
+		if tc.reference == nil {
+			// Without a reference, every endpoint counts the mismatch
+			for j, c := range clients {
+				if got := c.Stats.methods["eth_call"].numMismatched; got != 1 {
+					t.Errorf("%d: endpoint %d mismatched: got: %d; want: 1", i, j, got)
+				}
+			}
+		}
+	}
+}
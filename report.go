@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sort"
 	"sync"
 	"time"
 )
@@ -12,18 +13,54 @@ import (
 type report struct {
 	Clients Clients
 
+	// Comparator decides whether responses match, uses the default comparator if nil
+	Comparator *comparator
+
+	// Reference attributes mismatches to the endpoints which differ from it,
+	// instead of every endpoint
+	Reference *reference
+
+	// Recheck re-sends mismatched requests, and only counts mismatches which
+	// persist
+	Recheck *rechecker
+
+	// Schedule paces requests at a constant rate, if set
+	Schedule *schedule
+
+	// Search finds the maximum sustainable rate of each endpoint, if set
+	Search *searcher
+
+	// WarmupCompare compares responses to warm-up requests, and counts their
+	// mismatches separately
+	WarmupCompare bool
+
 	// MismatchedResponse is called when a response set does not match across clients
 	MismatchedResponse func([]Response)
+	// CompletedResponses is called when a response set is complete across clients
+	CompletedResponses func([]Response)
 
+	ctx              context.Context // For rechecks, set by Serve
 	skipCompare      bool
 	once             sync.Once
 	pendingResponses map[requestID][]Response
+	clientIndex      map[*Client]int
+	groups           mismatchGroups
 
 	requests   int // Number of requests
 	errors     int // Number of errors
-	mismatched int // Number of mismatched responses
-	completed  int // Number of completed responses across clients
-	overloaded int // Number of times reporting channel was overloaded
+	mismatched int // Number of mismatched response sets
+	undecided  int // Number of mismatched response sets without a reference response
+	transient  int // Number of mismatched response sets which matched on a recheck
+	persistent int // Number of mismatched response sets which didn't match on any recheck
+	rechecking int // Number of mismatched response sets being rechecked
+
+	warmup           int // Number of warm-up requests, excluded from the summary
+	warmupErrors     int // Number of errors to warm-up requests
+	warmupMismatched int // Number of mismatched warm-up response sets
+
+	stages     [][]stageStats // Stats of each endpoint in each load profile stage
+	completed  int            // Number of completed responses across clients
+	overloaded int            // Number of times reporting channel was overloaded
 
 	started time.Time     // Time when the report serving started
 	elapsed time.Duration // Total duration of requests
@@ -32,7 +69,11 @@ type report struct {
 func (r *report) Render(w io.Writer) error {
 	fmt.Fprintf(w, "Endpoints:\n")
 	for i, c := range r.Clients {
-		fmt.Fprintf(w, "\n%d. %q\n", i, c.Endpoint)
+		if c.Name != "" {
+			fmt.Fprintf(w, "\n%d. %s: %q\n", i, c.Name, c.Endpoint)
+		} else {
+			fmt.Fprintf(w, "\n%d. %q\n", i, c.Endpoint)
+		}
 		if err := c.Stats.Render(w); err != nil {
 			return err
 		}
@@ -44,7 +85,31 @@ func (r *report) Render(w io.Writer) error {
 		fmt.Fprintf(w, "   Timing:     %s request avg, %s total run time\n", r.elapsed/time.Duration(r.requests), time.Now().Sub(r.started))
 		fmt.Fprintf(w, "   Errors:     %d (%0.2f%%)\n", r.errors, float64(r.errors*100)/float64(r.requests))
 	}
+	if r.warmup > 0 {
+		fmt.Fprintf(w, "   Warm-up:    %d requests with %d errors", r.warmup, r.warmupErrors)
+		if r.WarmupCompare {
+			fmt.Fprintf(w, " and %d mismatches", r.warmupMismatched)
+		}
+		fmt.Fprintf(w, ", excluded from the summary\n")
+	}
+	if r.Schedule != nil {
+		r.Schedule.Render(w)
+		r.renderStages(w)
+	}
+	if r.Search != nil {
+		r.Search.Render(w)
+	}
 	fmt.Fprintf(w, "   Mismatched: %d\n", r.mismatched)
+	if r.Recheck != nil {
+		fmt.Fprintf(w, "   Rechecked:  %d transient, %d persistent mismatches\n", r.transient, r.persistent)
+		if r.rechecking > 0 {
+			fmt.Fprintf(w, "               %d mismatches were still being rechecked\n", r.rechecking)
+		}
+	}
+	if r.undecided > 0 {
+		fmt.Fprintf(w, "   Undecided:  %d mismatches without a reference response\n", r.undecided)
+	}
+	r.groups.Render(w, r.comparator())
 
 	if r.overloaded > 0 {
 		fmt.Fprintf(w, "** Reporting consumer was overloaded %d times. Please open an issue.\n", r.overloaded)
@@ -61,6 +126,52 @@ func (r *report) Render(w io.Writer) error {
 	return nil
 }
 
+func (r *report) comparator() *comparator {
+	if r.Comparator == nil {
+		return defaultComparator
+	}
+	return r.Comparator
+}
+
+func (r *report) equal(a, b Response) bool {
+	return r.comparator().Equal(a, b)
+}
+
+func (r *report) index(resp Response) int {
+	return r.clientIndex[resp.client]
+}
+
+// sorted returns a copy of the responses ordered by endpoint.
+func (r *report) sorted(resps []Response) []Response {
+	sorted := append([]Response(nil), resps...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return r.index(sorted[i]) < r.index(sorted[j])
+	})
+	return sorted
+}
+
+// attribute counts a mismatch for the endpoints which differ from the
+// reference, or every endpoint without a reference.
+func (r *report) attribute(resps []Response) {
+	outliers := resps
+	if r.Reference != nil {
+		if o, ok := r.Reference.Outliers(r.comparator(), resps, r.index); ok {
+			outliers = o
+			for _, resp := range outliers {
+				resp.client.Stats.CountDivergence()
+			}
+		} else {
+			r.undecided += 1
+		}
+	}
+	if resps[0].Request == nil {
+		return
+	}
+	for _, resp := range outliers {
+		resp.client.Stats.CountMismatch(resps[0].Request.Method)
+	}
+}
+
 func (r *report) count(err error, elapsed time.Duration) {
 	r.requests += 1
 	if err != nil {
@@ -79,22 +190,37 @@ func (r *report) compareResponses(resp Response) {
 	// All set, let's compare
 	otherResponses := r.pendingResponses[resp.ID]
 	delete(r.pendingResponses, resp.ID) // TODO: Reuse these arrays
+	if resp.Request != nil && resp.Request.Warmup {
+		r.compareWarmup(append(otherResponses, resp))
+		return
+	}
 	r.completed += 1
 
 	durations := make([]time.Duration, 0, len(r.Clients))
 	durations = append(durations, resp.Elapsed)
 
+	mismatched := false
 	for _, other := range otherResponses {
 		durations = append(durations, other.Elapsed)
 
-		if !other.Equal(resp) {
-			// Mismatch found, report the whole response set
-			r.mismatched += 1
-			if r.MismatchedResponse != nil {
-				otherResponses = append(otherResponses, resp)
-				r.MismatchedResponse(otherResponses)
-			}
+		if !r.equal(other, resp) {
+			mismatched = true
+		}
+	}
+	if mismatched && r.Recheck != nil && resp.Request != nil {
+		// Only count the mismatch once it's known to persist
+		r.rechecking += 1
+		ctx := r.ctx
+		if ctx == nil {
+			ctx = context.Background()
 		}
+		r.Recheck.Check(ctx, r.sorted(append(otherResponses, resp)))
+	} else if mismatched {
+		r.mismatch(r.sorted(append(otherResponses, resp)))
+	}
+
+	if r.CompletedResponses != nil {
+		r.CompletedResponses(append(otherResponses, resp))
 	}
 
 	// TODO: Check for JSONRPC error objects?
@@ -105,9 +231,54 @@ func (r *report) compareResponses(resp Response) {
 	l.Msg("result")
 }
 
+// mismatch counts a mismatched response set, ordered by endpoint.
+func (r *report) mismatch(resps []Response) {
+	r.mismatched += 1
+	r.attribute(resps)
+	r.groups.Add(r.comparator(), resps, r.index)
+	if r.MismatchedResponse != nil {
+		// Mismatch found, report the whole response set once
+		r.MismatchedResponse(resps)
+	}
+}
+
+// compareWarmup counts a mismatched warm-up response set separately, without
+// rechecking or attributing it.
+func (r *report) compareWarmup(resps []Response) {
+	for _, other := range resps[:len(resps)-1] {
+		if !r.equal(other, resps[len(resps)-1]) {
+			r.warmupMismatched += 1
+			if r.MismatchedResponse != nil {
+				r.MismatchedResponse(r.sorted(resps))
+			}
+			return
+		}
+	}
+}
+
+// rechecked counts the outcome of a recheck.
+func (r *report) rechecked(result recheckResult) {
+	r.rechecking -= 1
+	if result.Transient {
+		r.transient += 1
+		return
+	}
+	r.persistent += 1
+	r.mismatch(result.Responses)
+}
+
 func (r *report) handle(resp Response) error {
-	r.count(resp.Err, resp.Elapsed)
-	if r.skipCompare {
+	warmup := resp.Request != nil && resp.Request.Warmup
+	if warmup {
+		r.warmup += 1
+		if resp.Err != nil {
+			r.warmupErrors += 1
+		}
+	} else {
+		r.count(resp.Err, resp.Elapsed)
+		r.countStage(resp)
+	}
+	if r.skipCompare || (warmup && !r.WarmupCompare) {
 		return nil
 	}
 
@@ -118,6 +289,10 @@ func (r *report) handle(resp Response) error {
 func (r *report) init() {
 	r.once.Do(func() {
 		r.pendingResponses = make(map[requestID][]Response)
+		r.clientIndex = make(map[*Client]int, len(r.Clients))
+		for i, c := range r.Clients {
+			r.clientIndex[c] = i
+		}
 	})
 }
 
@@ -125,17 +300,32 @@ func (r *report) Serve(ctx context.Context, respCh <-chan Response) error {
 	r.init()
 
 	r.started = time.Now()
+	r.ctx = ctx
+	var rechecks <-chan recheckResult
+	if r.Recheck != nil {
+		rechecks = r.Recheck.Results()
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case resp, ok := <-respCh:
 			if !ok {
-				return nil
+				if r.rechecking == 0 {
+					return nil
+				}
+				// Wait for the remaining rechecks
+				respCh = nil
+				continue
 			}
 			if err := r.handle(resp); err != nil {
 				return err
 			}
+		case result := <-rechecks:
+			r.rechecked(result)
+			if respCh == nil && r.rechecking == 0 {
+				return nil
+			}
 		}
 	}
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 )
@@ -68,3 +71,141 @@ func TestReport(t *testing.T) {
 		t.Errorf("got: %d; want: %d", got, want)
 	}
 }
+
+func TestReportMismatchedSets(t *testing.T) {
+	clients, err := NewClients([]string{
+		"noop://foo",
+		"noop://bar",
+		"noop://baz",
+	}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := report{Clients: clients}
+	r.init()
+
+	// Two endpoints differ from the last one, which is still one mismatch
+	for i, body := range []string{"foo", "bar", "baz"} {
+		r.handle(Response{
+			client: clients[i],
+			ID:     1,
+			Body:   []byte(body),
+		})
+	}
+	if got, want := r.mismatched, 1; got != want {
+		t.Errorf("got: %d; want: %d", got, want)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	clients, err := NewClients([]string{
+		"noop://foo",
+		"noop://bar",
+	}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients[1].Name = "bar"
+
+	r := report{Clients: clients}
+	r.init()
+
+	for _, resp := range []Response{
+		{client: clients[0], ID: 1, Elapsed: time.Second},
+		{client: clients[1], ID: 1, Elapsed: time.Second, Body: []byte("foo")},
+		{client: clients[0], ID: 2, Elapsed: time.Second, Err: errors.New("oops")},
+	} {
+		resp.client.Stats.Count("", resp.Err, resp.Elapsed)
+		r.handle(resp)
+	}
+
+	var buf bytes.Buffer
+	if err := r.RenderJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var got jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != reportVersion {
+		t.Errorf("version got: %d; want: %d", got.Version, reportVersion)
+	}
+	if got, want := got.Summary, (jsonSummary{
+		Endpoints:      2,
+		Completed:      1,
+		Requests:       3,
+		Errors:         1,
+		ErrorRate:      1.0 / 3,
+		Mismatched:     1,
+		Incomplete:     1,
+		AverageRequest: 1,
+		RunTime:        got.Summary.RunTime,
+	}); got != want {
+		t.Errorf("summary got: %+v; want: %+v", got, want)
+	}
+
+	foo := got.Endpoints[0]
+	if foo.Requests != 2 || foo.Errors != 1 || foo.ErrorRate != 0.5 || foo.ErrorMessages["oops"] != 1 {
+		t.Errorf("unexpected endpoint: %+v", foo)
+	}
+	if got, want := foo.Timing.Percentiles["p99"], 1.0; got != want {
+		t.Errorf("p99 got: %0.4f; want: %0.4f", got, want)
+	}
+	if got, want := got.Endpoints[1].Name, "bar"; got != want {
+		t.Errorf("name got: %q; want: %q", got, want)
+	}
+}
+
+func TestReportMethods(t *testing.T) {
+	clients, err := NewClients([]string{
+		"noop://foo",
+		"noop://bar",
+	}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := report{Clients: clients}
+	r.init()
+
+	lines := []string{
+		`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"eth_getLogs"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"eth_blockNumber"}`,
+	}
+	for i, line := range lines {
+		req := &Request{ID: requestID(i), Line: []byte(line), Method: jsonrpcMethod([]byte(line))}
+		for j, c := range clients {
+			resp := Response{client: c, Request: req, ID: req.ID, Elapsed: time.Second}
+			if req.Method == "eth_getLogs" && j == 1 {
+				resp.Body = []byte("different")
+			}
+			c.Stats.Count(req.Method, resp.Err, resp.Elapsed)
+			r.handle(resp)
+		}
+	}
+
+	for _, c := range clients {
+		blockNumber, getLogs := c.Stats.methods["eth_blockNumber"], c.Stats.methods["eth_getLogs"]
+		if blockNumber.numTotal != 2 || blockNumber.numMismatched != 0 {
+			t.Errorf("%s eth_blockNumber got: %d requests, %d mismatched; want: 2, 0", c.Endpoint, blockNumber.numTotal, blockNumber.numMismatched)
+		}
+		if getLogs.numTotal != 1 || getLogs.numMismatched != 1 {
+			t.Errorf("%s eth_getLogs got: %d requests, %d mismatched; want: 1, 1", c.Endpoint, getLogs.numTotal, getLogs.numMismatched)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := r.RenderJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := got.Endpoints[0].Methods["eth_getLogs"].Mismatched, 1; got != want {
+		t.Errorf("json eth_getLogs mismatched got: %d; want: %d", got, want)
+	}
+}
@@ -11,13 +11,23 @@ type Request struct {
 
 	ID        requestID
 	Line      []byte
+	Method    string // JSON-RPC method of the request, if known
 	Timestamp time.Time
+
+	// Scheduled requests are timed from their Timestamp, which is when they
+	// were scheduled to be sent, rather than when they were sent.
+	Scheduled bool
+	Stage     int  // Index of the load profile stage of scheduled requests
+	Warmup    bool // Warm-up requests are excluded from the stats
 }
 
 func (req *Request) Do(t Transport) Response {
 	timeStarted := time.Now()
+	if req.Scheduled {
+		timeStarted = req.Timestamp
+	}
 	body, err := t.Send(req.Line)
-	return Response{
+	resp := Response{
 		client: req.client,
 
 		Request: req,
@@ -27,4 +37,9 @@ func (req *Request) Do(t Transport) Response {
 
 		Elapsed: time.Now().Sub(timeStarted),
 	}
+	if traced, ok := t.(Traced); ok {
+		phases := traced.Phases()
+		resp.Phases = &phases
+	}
+	return resp
 }
@@ -1,10 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"reflect"
 	"strings"
 	"time"
 )
@@ -18,58 +15,54 @@ type Response struct {
 	Err  error
 
 	Elapsed time.Duration
+	Phases  *phases // Of HTTP requests, if the transport traces them
 }
 
+// Equal returns true if the responses are equivalent, using the default
+// comparator.
 func (r *Response) Equal(other Response) bool {
-	if r.Err == nil && other.Err == nil {
-		// TODO: Use github.com/nsf/jsondiff to detect subsets and for pretty printing diffs?
-		return bytes.Equal(r.Body, other.Body) || jsonEqual(r.Body, other.Body)
-	}
-	if r.Err != nil && other.Err != nil {
-		return r.Err.Error() == other.Err.Error() && bytes.Equal(r.Body, other.Body)
-	}
-	return false
+	return defaultComparator.Equal(*r, other)
 }
 
 type Responses []Response
 
 func (resps Responses) String() string {
+	return resps.Diff(defaultComparator, diffFormat{})
+}
+
+// Diff describes how each response differs from the first response, as a
+// structural diff of their bodies or their errors.
+func (resps Responses) Diff(c *comparator, format diffFormat) string {
 	var buf strings.Builder
 
 	// TODO: Sort before printing
-	last := resps[0]
-	for i, resp := range resps {
-		fmt.Fprintf(&buf, "\t%s", resp.Elapsed)
-
-		if resp.Err == nil && last.Err == nil {
-			if !bytes.Equal(resp.Body, last.Body) {
-				fmt.Fprintf(&buf, "[%d: body mismatch:\n%s\n\t%s\n%s\n\t%s]", i, resp.client.Endpoint, resp.Body, last.client.Endpoint, last.Body)
-			}
-		} else if resp.Err != nil && last.Err != nil && resp.Err.Error() != last.Err.Error() {
-			fmt.Fprintf(&buf, "[%d: error mismatch: %s != %s]", i, resp.Err, last.Err)
-		} else {
-			fmt.Fprintf(&buf, "[%d: error mismatch: %s != %s]", i, resp.Err, last.Err)
+	first := resps[0]
+	for i, resp := range resps[1:] {
+		if c.Equal(first, resp) {
+			continue
 		}
+		fmt.Fprintf(&buf, "\n[0 vs %d: %s (%s) != %s (%s)]\n", i+1, first.endpoint(), first.Elapsed, resp.endpoint(), resp.Elapsed)
 
+		if first.Err != nil || resp.Err != nil {
+			fmt.Fprintf(&buf, "\terror mismatch: %v != %v\n", first.Err, resp.Err)
+			continue
+		}
+		var method string
+		if first.Request != nil {
+			method = first.Request.Method
+		}
+		format.Write(&buf, c, method, first.Body, resp.Body)
 	}
 
 	return buf.String()
 }
 
-// jsonEqual returns true if a and b are JSON objects (starting with '{') and equal.
-func jsonEqual(a, b []byte) bool {
-	if len(a) == 0 || a[0] != '{' {
-		return false
+func (r *Response) endpoint() string {
+	if r.client == nil {
+		return "unknown"
 	}
-
-	// Normalize a and b
-	var aObj, bObj interface{}
-	if err := json.Unmarshal(a, &aObj); err != nil {
-		return false
+	if r.client.Name != "" {
+		return r.client.Name
 	}
-	if err := json.Unmarshal(b, &bObj); err != nil {
-		return false
-	}
-
-	return reflect.DeepEqual(aObj, bObj)
+	return r.client.Endpoint
 }
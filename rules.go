@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// pathStep is a step of a path expression, selecting an object key or an
+// array index, or all of them with a wildcard.
+type pathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parsePath parses a path expression like "result.transactions[*].v", where
+// "*" selects every key or index.
+func parsePath(s string) ([]pathStep, error) {
+	var steps []pathStep
+	for _, segment := range strings.Split(s, ".") {
+		key := segment
+		if i := strings.Index(segment, "["); i >= 0 {
+			key = segment[:i]
+		}
+		if segment == "" {
+			return nil, fmt.Errorf("invalid path %q: empty segment", s)
+		}
+		if key != "" {
+			steps = append(steps, pathStep{key: key, wildcard: key == "*"})
+		}
+
+		for rest := segment[len(key):]; rest != ""; {
+			end := strings.Index(rest, "]")
+			if rest[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("invalid path %q: malformed index in %q", s, segment)
+			}
+			index := rest[1:end]
+			rest = rest[end+1:]
+			if index == "*" {
+				steps = append(steps, pathStep{isIndex: true, wildcard: true})
+				continue
+			}
+			n, err := strconv.Atoi(index)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid path %q: index must be a number or *: %q", s, index)
+			}
+			steps = append(steps, pathStep{isIndex: true, index: n})
+		}
+	}
+	return steps, nil
+}
+
+// deletePath removes the values selected by the path from v, returning the
+// updated value.
+func deletePath(v interface{}, steps []pathStep) interface{} {
+	if len(steps) == 0 {
+		return v
+	}
+	step, rest := steps[0], steps[1:]
+
+	if step.isIndex {
+		arr, ok := v.([]interface{})
+		if !ok {
+			return v
+		}
+		if len(rest) == 0 {
+			if step.wildcard {
+				return []interface{}{}
+			}
+			if step.index >= len(arr) {
+				return arr
+			}
+			return append(arr[:step.index:step.index], arr[step.index+1:]...)
+		}
+		for i := range arr {
+			if step.wildcard || i == step.index {
+				arr[i] = deletePath(arr[i], rest)
+			}
+		}
+		return arr
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	for key, value := range obj {
+		if !step.wildcard && key != step.key {
+			continue
+		}
+		if len(rest) == 0 {
+			delete(obj, key)
+		} else {
+			obj[key] = deletePath(value, rest)
+		}
+	}
+	return obj
+}
+
+// ignoreRule removes a path from responses before they're compared.
+type ignoreRule struct {
+	Method string // Only applies to requests with this method, if set
+	Path   []pathStep
+}
+
+// parseIgnoreRule parses a path expression, optionally prefixed by the
+// JSON-RPC method it applies to, like "eth_getBlockByNumber:result.size".
+func parseIgnoreRule(s string) (ignoreRule, error) {
+	var rule ignoreRule
+	if parts := strings.SplitN(s, ":", 2); len(parts) == 2 {
+		rule.Method, s = parts[0], parts[1]
+	}
+	path, err := parsePath(s)
+	if err != nil {
+		return rule, err
+	}
+	rule.Path = path
+	return rule, nil
+}
+
+// normalizer rewrites values that are formatted differently by different
+// implementations, but mean the same thing.
+type normalizer struct {
+	HexCase      bool // Lowercase hex strings, like "0xABC" to "0xabc"
+	LeadingZeros bool // Strip leading zeros of hex quantities, like "0x0a" to "0xa"
+	Quantities   bool // Convert integer numbers to hex strings, like 10 to "0xa"
+}
+
+// parseNormalizer parses a list of normalizer names.
+func parseNormalizer(names []string) (normalizer, error) {
+	var n normalizer
+	for _, name := range names {
+		for _, name := range strings.Split(name, ",") {
+			switch strings.TrimSpace(name) {
+			case "hex-case":
+				n.HexCase = true
+			case "leading-zeros":
+				n.LeadingZeros = true
+			case "quantities":
+				n.Quantities = true
+			case "all":
+				n = normalizer{true, true, true}
+			case "":
+			default:
+				return n, fmt.Errorf("invalid normalizer: %s", name)
+			}
+		}
+	}
+	return n, nil
+}
+
+func (n normalizer) enabled() bool {
+	return n.HexCase || n.LeadingZeros || n.Quantities
+}
+
+// Normalize returns the value with every nested value normalized.
+func (n normalizer) Normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = n.Normalize(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = n.Normalize(value)
+		}
+		return v
+	case string:
+		return n.normalizeHex(v)
+	case json.Number:
+		if !n.Quantities {
+			return v
+		}
+		i, ok := new(big.Int).SetString(v.String(), 10)
+		if !ok || i.Sign() < 0 {
+			return v
+		}
+		return "0x" + i.Text(16)
+	}
+	return v
+}
+
+// minDataDigits is the fewest hex digits of fixed-width data, like the 8 byte
+// block nonce. Addresses and hashes are longer.
+const minDataDigits = 16
+
+// isHexData returns true if the hex string looks like fixed-width data rather
+// than a quantity, where leading zeros are significant: an even number of
+// digits, and at least minDataDigits of them.
+func isHexData(s string) bool {
+	digits := len(s) - 2
+	return digits%2 == 0 && digits >= minDataDigits
+}
+
+func (n normalizer) normalizeHex(s string) string {
+	if len(s) < 3 || s[0] != '0' || (s[1] != 'x' && s[1] != 'X') {
+		return s
+	}
+	for _, c := range s[2:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return s
+		}
+	}
+	if n.HexCase {
+		s = strings.ToLower(s)
+	}
+	if n.LeadingZeros && !isHexData(s) {
+		digits := strings.TrimLeft(s[2:], "0")
+		if digits == "" {
+			digits = "0"
+		}
+		s = s[:2] + digits
+	}
+	return s
+}
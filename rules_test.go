@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []pathStep
+		err  bool
+	}{
+		{"result.totalDifficulty", []pathStep{{key: "result"}, {key: "totalDifficulty"}}, false},
+		{"result.transactions[*].v", []pathStep{{key: "result"}, {key: "transactions"}, {isIndex: true, wildcard: true}, {key: "v"}}, false},
+		{"result[0][1].*", []pathStep{{key: "result"}, {isIndex: true}, {isIndex: true, index: 1}, {key: "*", wildcard: true}}, false},
+		{"result..foo", nil, true},
+		{"result[foo]", nil, true},
+		{"result[0", nil, true},
+	}
+
+	for _, tc := range tests {
+		got, err := parsePath(tc.path)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: got error: %v; want error: %t", tc.path, err, tc.err)
+			continue
+		}
+		if string(mustJSON(t, got)) != string(mustJSON(t, tc.want)) {
+			t.Errorf("%s: got: %+v; want: %+v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDeletePath(t *testing.T) {
+	tests := []struct {
+		path string
+		in   string
+		want string
+	}{
+		{"result.totalDifficulty", `{"result":{"number":"0x1","totalDifficulty":"0x2"}}`, `{"result":{"number":"0x1"}}`},
+		{"result.transactions[*].v", `{"result":{"transactions":[{"v":"0x1","r":"0x2"},{"v":"0x3"}]}}`, `{"result":{"transactions":[{"r":"0x2"},{}]}}`},
+		{"result[1]", `{"result":["a","b","c"]}`, `{"result":["a","c"]}`},
+		{"result[5]", `{"result":["a"]}`, `{"result":["a"]}`},
+		{"result[*]", `{"result":["a","b"]}`, `{"result":[]}`},
+		{"result.*.size", `{"result":{"a":{"size":1,"x":2},"b":{"size":3}}}`, `{"result":{"a":{"x":2},"b":{}}}`},
+		{"result.missing.deeper", `{"result":"0x1"}`, `{"result":"0x1"}`},
+	}
+
+	for _, tc := range tests {
+		path, err := parsePath(tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(tc.in), &v); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(mustJSON(t, deletePath(v, path))); got != tc.want {
+			t.Errorf("%s: got: %s; want: %s", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizer(t *testing.T) {
+	n, err := parseNormalizer([]string{"hex-case,leading-zeros", "quantities"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (normalizer{true, true, true}); n != want {
+		t.Errorf("got: %+v; want: %+v", n, want)
+	}
+	if _, err := parseNormalizer([]string{"bogus"}); err == nil {
+		t.Error("expected error for invalid normalizer")
+	}
+
+	tests := []struct {
+		normalizer normalizer
+		in         string
+		want       string
+	}{
+		{normalizer{HexCase: true}, `["0xABcd","0X0A","0xnothex","ABCD"]`, `["0xabcd","0x0a","0xnothex","ABCD"]`},
+		{normalizer{LeadingZeros: true}, `["0x000a","0x0","0x00","0x"]`, `["0xa","0x0","0x0","0x"]`},
+		// Fixed-width data like hashes and addresses keeps its leading zeros
+		{normalizer{LeadingZeros: true}, `["0x0000000000000000","0x00ab000000000000000000000000000000000000","0x000000000000000000a"]`, `["0x0000000000000000","0x00ab000000000000000000000000000000000000","0xa"]`},
+		{normalizer{Quantities: true}, `{"a":10,"b":1.5,"c":-1,"d":0}`, `{"a":"0xa","b":1.5,"c":-1,"d":"0x0"}`},
+	}
+
+	for _, tc := range tests {
+		v, err := decodeJSON([]byte(tc.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(mustJSON(t, tc.normalizer.Normalize(v))); got != tc.want {
+			t.Errorf("%+v %s: got: %s; want: %s", tc.normalizer, tc.in, got, tc.want)
+		}
+	}
+}
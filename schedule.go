@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errScheduleDone is returned when every stage of a load profile is done.
+var errScheduleDone = errors.New("load profile is done")
+
+// maxScheduledBacklog is how many scheduled requests can wait for each
+// endpoint, so that a slow endpoint doesn't hold back sends to the others.
+const maxScheduledBacklog = 10000
+
+// parseRate parses a rate like "500/s", "100/250ms" or "500" (per second), and
+// returns the interval between requests.
+func parseRate(s string) (time.Duration, error) {
+	n, per, err := parseRateParts(s)
+	if err != nil {
+		return 0, err
+	}
+	interval := time.Duration(float64(per) / n)
+	if interval <= 0 {
+		return 0, fmt.Errorf("rate is too high: %s", s)
+	}
+	return interval, nil
+}
+
+// parseRatePerSecond parses a rate like parseRate, in requests per second.
+func parseRatePerSecond(s string) (float64, error) {
+	n, per, err := parseRateParts(s)
+	if err != nil {
+		return 0, err
+	}
+	return n / per.Seconds(), nil
+}
+
+func parseRateParts(s string) (float64, time.Duration, error) {
+	count, per := s, "s"
+	if i := strings.Index(s, "/"); i >= 0 {
+		count, per = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate, must be a positive number of requests like \"500/s\": %s", s)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate period: %s", s)
+	}
+	return n, d, nil
+}
+
+// stage is a period of a load profile, where the rate changes linearly from
+// one rate to another.
+type stage struct {
+	Name     string
+	From, To float64 // Requests per second at the start and end of the stage
+	Duration time.Duration
+}
+
+// parseStage parses a stage of a load profile, which can expand to several
+// stages:
+//
+//	hold:RATE:DURATION            Constant rate
+//	spike:RATE:DURATION           Constant rate, named as a spike
+//	ramp:FROM-TO:DURATION         Linear change from one rate to another
+//	steps:FROM-TO:COUNT:DURATION  Constant rates increasing in COUNT steps,
+//	                              each held for the duration
+func parseStage(s string) ([]stage, error) {
+	parts := strings.Split(s, ":")
+	invalid := fmt.Errorf("invalid stage: %s", s)
+	if len(parts) < 3 {
+		return nil, invalid
+	}
+	duration, err := time.ParseDuration(parts[len(parts)-1])
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("invalid stage duration: %s", s)
+	}
+
+	// Rates of ramps and steps are a range
+	var from, to float64
+	rates := strings.SplitN(parts[1], "-", 2)
+	if from, err = parseRatePerSecond(rates[0]); err != nil {
+		return nil, err
+	}
+	to = from
+	if len(rates) == 2 {
+		if to, err = parseRatePerSecond(rates[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	switch kind := parts[0]; {
+	case (kind == "hold" || kind == "spike") && len(parts) == 3 && len(rates) == 1:
+		return []stage{{Name: fmt.Sprintf("%s %s for %s", kind, parts[1], duration), From: from, To: from, Duration: duration}}, nil
+	case kind == "ramp" && len(parts) == 3 && len(rates) == 2:
+		return []stage{{Name: fmt.Sprintf("ramp %s for %s", parts[1], duration), From: from, To: to, Duration: duration}}, nil
+	case kind == "steps" && len(parts) == 4 && len(rates) == 2:
+		count, err := strconv.Atoi(parts[2])
+		if err != nil || count < 2 {
+			return nil, fmt.Errorf("invalid number of steps, must be at least 2: %s", s)
+		}
+		stages := make([]stage, 0, count)
+		for i := 0; i < count; i++ {
+			rate := from + (to-from)*float64(i)/float64(count-1)
+			stages = append(stages, stage{
+				Name:     fmt.Sprintf("step %d/%d %0.2f/s for %s", i+1, count, rate, duration),
+				From:     rate,
+				To:       rate,
+				Duration: duration,
+			})
+		}
+		return stages, nil
+	}
+	return nil, invalid
+}
+
+// schedule paces requests at a constant rate, or following the stages of a
+// load profile, regardless of how long responses take (open-loop). It counts
+// how far behind schedule requests were sent. Requests are timed from their
+// scheduled time, so that slow responses which hold up later requests are
+// included in the timing.
+type schedule struct {
+	Interval time.Duration // Between requests at a constant rate
+	Stages   []stage       // Load profile, instead of a constant rate
+
+	start    time.Time
+	first    time.Time     // When the first request was sent
+	last     time.Time     // When the last request was sent
+	next     time.Time     // When the next request is scheduled, for stages
+	interval time.Duration // Current interval between requests
+	n        int
+
+	behind histogram // Seconds between the scheduled and actual send time
+	late   int       // Requests sent more than an interval behind schedule
+	sent   []int     // Requests sent in each stage
+}
+
+// Next waits until the scheduled time of the next request and returns it,
+// with the index of its stage. Returns errScheduleDone after the last stage.
+func (s *schedule) Next(ctx context.Context) (time.Time, int, error) {
+	if s.start.IsZero() {
+		s.start = time.Now()
+		s.next = s.start
+	}
+	at, stage, ok := s.schedule()
+	if !ok {
+		return at, stage, errScheduleDone
+	}
+
+	if wait := time.Until(at); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return at, stage, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return at, stage, nil
+}
+
+// schedule returns the time and stage of the next request.
+func (s *schedule) schedule() (time.Time, int, bool) {
+	if len(s.Stages) == 0 {
+		at := s.start.Add(time.Duration(s.n) * s.Interval)
+		s.n += 1
+		s.interval = s.Interval
+		return at, 0, true
+	}
+
+	at := s.next
+	offset := at.Sub(s.start)
+	var stageStart time.Duration
+	for i, st := range s.Stages {
+		stageEnd := stageStart + st.Duration
+		if offset >= stageEnd {
+			stageStart = stageEnd
+			continue
+		}
+		progress := float64(offset-stageStart) / float64(st.Duration)
+		rate := st.From + (st.To-st.From)*progress
+		s.interval = time.Duration(float64(time.Second) / rate)
+		s.next = at.Add(s.interval)
+		if end := s.start.Add(stageEnd); s.next.After(end) {
+			// Don't skip into the next stage at a slow rate
+			s.next = end
+		}
+		s.n += 1
+		return at, i, true
+	}
+	return at, 0, false
+}
+
+// Sent counts a request scheduled at the time which was just sent.
+func (s *schedule) Sent(at time.Time, stage int) {
+	s.last = time.Now()
+	if s.first.IsZero() {
+		s.first = s.last
+	}
+	behind := s.last.Sub(at)
+	s.behind.Add(behind.Seconds())
+	if behind > s.interval {
+		s.late += 1
+	}
+	if len(s.Stages) > 0 {
+		if s.sent == nil {
+			s.sent = make([]int, len(s.Stages))
+		}
+		s.sent[stage] += 1
+	}
+}
+
+// Rate returns the target rate, in requests per second. For load profiles,
+// it's the average rate of every stage.
+func (s *schedule) Rate() float64 {
+	if len(s.Stages) == 0 {
+		return float64(time.Second) / float64(s.Interval)
+	}
+	var requests, seconds float64
+	for _, st := range s.Stages {
+		requests += (st.From + st.To) / 2 * st.Duration.Seconds()
+		seconds += st.Duration.Seconds()
+	}
+	return requests / seconds
+}
+
+// SentRate returns the actual rate requests were sent at, per second, from the
+// intervals between them.
+func (s *schedule) SentRate() float64 {
+	return ratio(float64(s.behind.Len()-1), s.last.Sub(s.first).Seconds())
+}
+
+func (s *schedule) Render(w io.Writer) {
+	fmt.Fprintf(w, "   Schedule:   %0.2f per second target, %0.2f per second sent, %d late\n", s.Rate(), s.SentRate(), s.late)
+	if s.behind.Len() > 0 {
+		fmt.Fprintf(w, "               %0.4fs avg, %0.4fs max behind schedule\n", s.behind.Average(), s.behind.Max())
+	}
+}
+
+// stageStats are the stats of an endpoint during a stage of a load profile.
+type stageStats struct {
+	numTotal  int
+	numErrors int
+	timing    histogram
+}
+
+// countStage counts a response in the stats of its stage.
+func (r *report) countStage(resp Response) {
+	if r.Schedule == nil || len(r.Schedule.Stages) == 0 || resp.Request == nil {
+		return
+	}
+	if r.stages == nil {
+		r.stages = make([][]stageStats, len(r.Schedule.Stages))
+		for i := range r.stages {
+			r.stages[i] = make([]stageStats, len(r.Clients))
+		}
+	}
+	stats := &r.stages[resp.Request.Stage][r.index(resp)]
+	stats.numTotal += 1
+	if resp.Err != nil {
+		stats.numErrors += 1
+	}
+	stats.timing.Add(resp.Elapsed.Seconds())
+}
+
+func (r *report) renderStages(w io.Writer) {
+	if r.stages == nil {
+		return
+	}
+	fmt.Fprintf(w, "   Stages:\n")
+	for i, st := range r.Schedule.Stages {
+		var sent int
+		if r.Schedule.sent != nil {
+			sent = r.Schedule.sent[i]
+		}
+		fmt.Fprintf(w, "   %d. %s: %d sent, %0.2f per second\n", i+1, st.Name, sent, float64(sent)/st.Duration.Seconds())
+		for j := range r.stages[i] {
+			stats := &r.stages[i][j]
+			if stats.numTotal == 0 {
+				continue
+			}
+			percentiles := stats.timing.Percentiles(50, 95, 99)
+			errRate := float64(stats.numErrors*100) / float64(stats.numTotal)
+			fmt.Fprintf(w, "      %d. %d requests, %0.2f%% errors, %0.4fs avg, %0.4fs p50, %0.4fs p95, %0.4fs p99\n", j, stats.numTotal, errRate, stats.timing.Average(), percentiles[0], percentiles[1], percentiles[2])
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate string
+		want time.Duration
+		err  bool
+	}{
+		{"500/s", 2 * time.Millisecond, false},
+		{"500", 2 * time.Millisecond, false},
+		{"60/m", time.Second, false},
+		{"10/100ms", 10 * time.Millisecond, false},
+		{"0.5/s", 2 * time.Second, false},
+		{"0/s", 0, true},
+		{"fast", 0, true},
+		{"10/fortnight", 0, true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseRate(tc.rate)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("%s: got: %s %v; want: %s, error %t", tc.rate, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	s := schedule{Interval: 10 * time.Millisecond}
+	ctx := context.Background()
+
+	started := time.Now()
+	var last time.Time
+	for i := 0; i < 5; i++ {
+		at, _, err := s.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && at.Sub(last) != s.Interval {
+			t.Errorf("%d: scheduled %s after the last request; want %s", i, at.Sub(last), s.Interval)
+		}
+		last = at
+		s.Sent(at, 0)
+	}
+	if elapsed := time.Since(started); elapsed < 4*s.Interval {
+		t.Errorf("5 requests took %s; want at least %s", elapsed, 4*s.Interval)
+	}
+	if got, want := s.Rate(), 100.0; got != want {
+		t.Errorf("rate got: %g; want: %g", got, want)
+	}
+
+	// Falling behind schedule counts late requests
+	time.Sleep(5 * s.Interval)
+	at, _, err := s.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Sent(at, 0)
+	if s.late != 1 || s.behind.Max() < s.Interval.Seconds() {
+		t.Errorf("got: %d late, %gs max behind; want 1 late", s.late, s.behind.Max())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	s.n += 100
+	if _, _, err := s.Next(ctx); err != context.Canceled {
+		t.Errorf("got: %v; want: %v", err, context.Canceled)
+	}
+}
+
+func TestScheduleSentRate(t *testing.T) {
+	tests := []struct {
+		requests int
+		elapsed  time.Duration // From the first to the last request
+		want     float64
+	}{
+		{0, 0, 0},
+		{1, 0, 0},
+		{2, time.Second, 1},
+		{5, time.Second, 4},
+		{4, 1500 * time.Millisecond, 2},
+	}
+
+	started := time.Now()
+	for _, tc := range tests {
+		s := schedule{first: started, last: started.Add(tc.elapsed)}
+		for i := 0; i < tc.requests; i++ {
+			s.behind.Add(0)
+		}
+		if got := s.SentRate(); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%d requests in %s: got: %g per second; want: %g", tc.requests, tc.elapsed, got, tc.want)
+		}
+	}
+}
+
+func TestParseStage(t *testing.T) {
+	tests := []struct {
+		stage string
+		want  []stage
+		err   bool
+	}{
+		{"hold:100/s:1m", []stage{{"hold 100/s for 1m0s", 100, 100, time.Minute}}, false},
+		{"spike:1000:10s", []stage{{"spike 1000 for 10s", 1000, 1000, 10 * time.Second}}, false},
+		{"ramp:10-100/s:30s", []stage{{"ramp 10-100/s for 30s", 10, 100, 30 * time.Second}}, false},
+		{"steps:10-30:3:5s", []stage{
+			{"step 1/3 10.00/s for 5s", 10, 10, 5 * time.Second},
+			{"step 2/3 20.00/s for 5s", 20, 20, 5 * time.Second},
+			{"step 3/3 30.00/s for 5s", 30, 30, 5 * time.Second},
+		}, false},
+		{"hold:100:forever", nil, true},
+		{"hold:10-100:1m", nil, true},
+		{"ramp:100:1m", nil, true},
+		{"steps:10-30:1:5s", nil, true},
+		{"soak:100:1h", nil, true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseStage(tc.stage)
+		if (err != nil) != tc.err || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got: %v %v; want: %v, error %t", tc.stage, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestScheduleStages(t *testing.T) {
+	s := schedule{Stages: []stage{
+		{From: 100, To: 300, Duration: 50 * time.Millisecond},
+		{From: 100, To: 100, Duration: 50 * time.Millisecond},
+	}}
+	ctx := context.Background()
+
+	var stages []int
+	var last time.Time
+	for {
+		at, stage, err := s.Next(ctx)
+		if err == errScheduleDone {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if len(stages) > 0 && stage == 1 && stages[len(stages)-1] == 1 && at.Sub(last) != 10*time.Millisecond {
+			t.Errorf("held at %s after the last request; want %s", at.Sub(last), 10*time.Millisecond)
+		}
+		stages = append(stages, stage)
+		last = at
+		s.Sent(at, stage)
+	}
+
+	// The ramp averages 200/s over 50ms, then 100/s for 50ms
+	if s.sent[0] < 8 || s.sent[0] > 12 || s.sent[1] != 5 {
+		t.Errorf("got: %v sent in each stage; want about [10 5]", s.sent)
+	}
+	for i := 1; i < len(stages); i++ {
+		if stages[i] < stages[i-1] {
+			t.Errorf("stages out of order: %v", stages)
+		}
+	}
+	if got, want := s.Rate(), 150.0; got != want {
+		t.Errorf("rate got: %g; want: %g", got, want)
+	}
+}
+
+func TestScheduledRequest(t *testing.T) {
+	req := Request{Timestamp: time.Now().Add(-time.Second), Scheduled: true}
+	if resp := req.Do(&noopTransport{}); resp.Elapsed < time.Second {
+		t.Errorf("got: %s; want timing from the scheduled time", resp.Elapsed)
+	}
+	req.Scheduled = false
+	if resp := req.Do(&noopTransport{}); resp.Elapsed >= time.Second {
+		t.Errorf("got: %s; want timing from the send time", resp.Elapsed)
+	}
+}
+
+func TestScheduledSlowEndpoint(t *testing.T) {
+	// A slow endpoint doesn't hold back requests to a fast endpoint
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`"slow"`))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`"fast"`))
+	}))
+	defer fast.Close()
+
+	clients, err := NewClients([]string{slow.URL, fast.URL}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients.Backlog(maxScheduledBacklog)
+
+	ctx := context.Background()
+	responses := make(chan Response, 100)
+	done := make(chan error)
+	go func() {
+		done <- clients.Serve(ctx, responses)
+		close(responses)
+	}()
+
+	lines := strings.Repeat(`{"method":"eth_blockNumber"}`+"\n", 10)
+	sched := &schedule{Interval: 5 * time.Millisecond}
+	if err := pump(ctx, bufio.NewScanner(strings.NewReader(lines)), clients, 0, sched, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for resp := range responses {
+		if resp.client == clients[1] && resp.Elapsed > 40*time.Millisecond {
+			t.Errorf("fast endpoint got: %s; want it timed without waiting for the slow endpoint", resp.Elapsed)
+		}
+	}
+	if got := clients[0].Stats.timing.Max(); got < 0.2 {
+		t.Errorf("slow endpoint got: %gs max; want its backlog included in the timing", got)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// maxSearchTrials bounds the number of trials per endpoint, so that a
+	// noisy endpoint can't be searched forever.
+	maxSearchTrials = 20
+
+	// searchPrecision is how close the highest rate which met the SLO must be
+	// to the lowest rate which breached it, as a fraction, to stop searching.
+	searchPrecision = 0.05
+)
+
+// errSourceDone is returned when the source runs out of requests mid-search.
+var errSourceDone = errors.New("source ran out of requests")
+
+// slo is a service level objective that a rate of requests must meet.
+type slo struct {
+	Percentile float64       // Latency percentile, like 99
+	Latency    time.Duration // Maximum latency at the percentile, if set
+	ErrorRate  float64       // Maximum fraction of errors, if set
+}
+
+// parseSLO parses an SLO like "p99<200ms,errors<1%".
+func parseSLO(s string) (slo, error) {
+	var o slo
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "<", 2)
+		if len(kv) != 2 {
+			return o, fmt.Errorf("invalid SLO, must be like \"p99<200ms,errors<1%%\": %s", s)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch {
+		case key == "errors":
+			rate, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			if err != nil || rate < 0 || rate > 100 {
+				return o, fmt.Errorf("invalid SLO error rate: %s", part)
+			}
+			o.ErrorRate = rate / 100
+		case strings.HasPrefix(key, "p"):
+			p, err := strconv.ParseFloat(key[1:], 64)
+			if err != nil || p <= 0 || p > 100 {
+				return o, fmt.Errorf("invalid SLO percentile: %s", part)
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return o, fmt.Errorf("invalid SLO latency: %s", part)
+			}
+			o.Percentile, o.Latency = p, d
+		default:
+			return o, fmt.Errorf("invalid SLO, must be like \"p99<200ms,errors<1%%\": %s", s)
+		}
+	}
+	return o, nil
+}
+
+func (o slo) String() string {
+	var parts []string
+	if o.Latency > 0 {
+		parts = append(parts, fmt.Sprintf("p%g < %s", o.Percentile, o.Latency))
+	}
+	return strings.Join(append(parts, fmt.Sprintf("errors < %g%%", o.ErrorRate*100)), ", ")
+}
+
+// Met returns true if the trial meets the objective. Without an error rate,
+// any error breaches the objective.
+func (o slo) Met(t searchTrial) bool {
+	if t.Requests == 0 {
+		return false
+	}
+	if t.Errors > 0 && float64(t.Errors)/float64(t.Requests) >= o.ErrorRate {
+		return false
+	}
+	return o.Latency == 0 || t.Latency < o.Latency.Seconds()
+}
+
+// searchTrial is the outcome of sending requests at a rate for a while.
+type searchTrial struct {
+	Rate     float64 // Target requests per second
+	Requests int
+	Errors   int
+	Latency  float64 // At the SLO percentile, in seconds
+	Met      bool
+}
+
+// searchResult is the maximum sustainable rate found for an endpoint.
+type searchResult struct {
+	Max      float64 // Highest rate which met the SLO, 0 if none did
+	Breached float64 // Lowest rate which breached the SLO, 0 if none did
+	Trials   []searchTrial
+	Err      error // Why the search stopped early, if it did
+}
+
+// searcher finds the maximum rate of requests that each endpoint can sustain
+// while meeting an SLO. It doubles the rate from Start until the SLO is
+// breached, then binary searches between the last rates which met and
+// breached it. Rates below Start aren't searched. Each endpoint is searched
+// independently with its own requests from the source, which are counted in
+// its stats but not compared.
+type searcher struct {
+	SLO       slo
+	Start     float64       // Initial requests per second
+	Duration  time.Duration // Of each trial
+	Precision float64       // Percentile precision of trial timings
+
+	mu      sync.Mutex
+	scanner lineScanner
+	done    bool
+	id      requestID
+	results []searchResult
+}
+
+func newSearcher(scanner lineScanner, s, start, duration string) (*searcher, error) {
+	o, err := parseSLO(s)
+	if err != nil {
+		return nil, err
+	}
+	rate, err := parseRatePerSecond(start)
+	if err != nil {
+		return nil, err
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid search trial duration: %s", duration)
+	}
+	return &searcher{
+		SLO:      o,
+		Start:    rate,
+		Duration: d,
+		scanner:  scanner,
+	}, nil
+}
+
+// next returns the next request for an endpoint, shared between the searches
+// of every endpoint.
+func (s *searcher) next() (Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done || !s.scanner.Scan() || len(s.scanner.Bytes()) == 0 {
+		s.done = true
+		if err := s.scanner.Err(); err != nil {
+			return Request{}, err
+		}
+		return Request{}, errSourceDone
+	}
+	s.id += 1
+	line := append([]byte(nil), s.scanner.Bytes()...)
+	return Request{ID: s.id, Line: line, Method: jsonrpcMethod(line)}, nil
+}
+
+// Run searches every endpoint concurrently, sending their responses to out.
+func (s *searcher) Run(ctx context.Context, clients Clients, out chan<- Response) error {
+	results := make([]searchResult, len(clients))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, c := range clients {
+		i, c := i, c
+		g.Go(func() error {
+			result, err := s.Search(ctx, c, out)
+			results[i] = result
+			return err
+		})
+	}
+	err := g.Wait()
+
+	s.mu.Lock()
+	s.results = results
+	s.mu.Unlock()
+	return err
+}
+
+// Results returns the search result of each endpoint, once Run is done.
+func (s *searcher) Results() []searchResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.results
+}
+
+// Search finds the maximum sustainable rate of an endpoint. Running out of
+// requests or time ends the search early with the rates found so far.
+func (s *searcher) Search(ctx context.Context, c *Client, out chan<- Response) (searchResult, error) {
+	var result searchResult
+
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	transports := make([]Transport, concurrency)
+	for i := range transports {
+		if i > 0 && isShared(transports[0]) {
+			transports[i] = transports[0]
+			continue
+		}
+		t, err := NewTransport(c.Endpoint, c.Timeout, c.Header)
+		if err != nil {
+			return result, err
+		}
+		defer closeTransport(t)
+		transports[i] = t
+	}
+
+	rate := s.Start
+	for len(result.Trials) < maxSearchTrials {
+		trial, err := s.trial(ctx, c, transports, rate, out)
+		if err == errSourceDone || err == context.Canceled || err == context.DeadlineExceeded {
+			logger.Warn().Str("endpoint", c.Endpoint).Err(err).Msg("search stopped early")
+			result.Err = err
+			return result, nil
+		} else if err != nil {
+			return result, err
+		}
+		result.Trials = append(result.Trials, trial)
+		logger.Info().Str("endpoint", c.Endpoint).Float64("rate", rate).Int("requests", trial.Requests).Int("errors", trial.Errors).Float64("latency", trial.Latency).Bool("met", trial.Met).Msg("search trial")
+
+		var ok bool
+		if rate, ok = result.next(trial, s.Start); !ok {
+			break
+		}
+	}
+	return result, nil
+}
+
+// next counts a trial, and returns the rate of the next trial. Returns false
+// when the boundary is found within searchPrecision, or when even the minimum
+// rate breaches the SLO.
+func (result *searchResult) next(trial searchTrial, min float64) (float64, bool) {
+	if trial.Met {
+		result.Max = trial.Rate
+	} else {
+		result.Breached = trial.Rate
+	}
+	if result.Max == 0 && result.Breached <= min {
+		// There's no lower rate to search
+		return 0, false
+	}
+	if result.Breached == 0 {
+		// Still looking for the breaking point
+		return trial.Rate * 2, true
+	}
+	if (result.Breached-result.Max)/result.Breached <= searchPrecision {
+		return 0, false
+	}
+	return (result.Max + result.Breached) / 2, true
+}
+
+// trial sends requests to the endpoint at a constant rate for the trial
+// duration, and measures them against the SLO.
+func (s *searcher) trial(ctx context.Context, c *Client, transports []Transport, rate float64, out chan<- Response) (searchTrial, error) {
+	trial := searchTrial{Rate: rate}
+	timing := histogram{Precision: s.Precision}
+	var mu sync.Mutex
+
+	requests := make(chan Request)
+	g, ctx := errgroup.WithContext(ctx)
+	for _, t := range transports {
+		t := t
+		g.Go(func() error {
+			for req := range requests {
+				req := req
+				resp := req.Do(t)
+				c.Stats.Count(req.Method, resp.Err, resp.Elapsed)
+				if resp.Phases != nil {
+					c.Stats.CountPhases(*resp.Phases)
+				}
+
+				mu.Lock()
+				trial.Requests += 1
+				if resp.Err != nil {
+					trial.Errors += 1
+				}
+				timing.Add(resp.Elapsed.Seconds())
+				mu.Unlock()
+
+				select {
+				case out <- resp:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	}
+
+	sched := schedule{Interval: time.Duration(float64(time.Second) / rate)}
+	end := time.Now().Add(s.Duration)
+	// Don't wait for requests scheduled after the end of the trial
+	schedCtx, cancel := context.WithDeadline(ctx, end)
+	defer cancel()
+	err := func() error {
+		defer close(requests)
+		for {
+			at, _, err := sched.Next(schedCtx)
+			if !at.Before(end) || (err != nil && ctx.Err() == nil) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			req, err := s.next()
+			if err != nil {
+				return err
+			}
+			req.client = c
+			req.Timestamp = at
+			req.Scheduled = true
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}()
+	if werr := g.Wait(); err == nil {
+		err = werr
+	}
+	if err != nil {
+		return trial, err
+	}
+
+	if s.SLO.Latency > 0 {
+		trial.Latency = timing.Percentiles(s.SLO.Percentile)[0]
+	}
+	trial.Met = s.SLO.Met(trial)
+	return trial, nil
+}
+
+func (s *searcher) Render(w io.Writer) {
+	fmt.Fprintf(w, "   Search:     max sustainable rate for %s\n", s.SLO)
+	for i, result := range s.Results() {
+		fmt.Fprintf(w, "   %d. ", i)
+		if result.Max == 0 {
+			fmt.Fprintf(w, "no rate met the objective")
+		} else {
+			fmt.Fprintf(w, "%0.2f per second", result.Max)
+		}
+		if result.Breached > 0 {
+			fmt.Fprintf(w, ", breached at %0.2f per second", result.Breached)
+		} else {
+			fmt.Fprintf(w, ", not breached")
+		}
+		fmt.Fprintf(w, " (%d trials)", len(result.Trials))
+		if result.Err != nil {
+			fmt.Fprintf(w, ", stopped early: %s", result.Err)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSLO(t *testing.T) {
+	tests := []struct {
+		slo  string
+		want slo
+		err  bool
+	}{
+		{"p99<200ms,errors<1%", slo{Percentile: 99, Latency: 200 * time.Millisecond, ErrorRate: 0.01}, false},
+		{"errors<5%", slo{ErrorRate: 0.05}, false},
+		{"p99.9 < 1s", slo{Percentile: 99.9, Latency: time.Second}, false},
+		{"p99>200ms", slo{}, true},
+		{"p0<200ms", slo{}, true},
+		{"p99<fast", slo{}, true},
+		{"errors<lots", slo{}, true},
+		{"avg<200ms", slo{}, true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseSLO(tc.slo)
+		if (err != nil) != tc.err || (!tc.err && got != tc.want) {
+			t.Errorf("%s: got: %+v %v; want: %+v, error %t", tc.slo, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestSLOMet(t *testing.T) {
+	o := slo{Percentile: 99, Latency: 200 * time.Millisecond, ErrorRate: 0.01}
+	tests := []struct {
+		trial searchTrial
+		want  bool
+	}{
+		{searchTrial{Requests: 100, Errors: 0, Latency: 0.1}, true},
+		{searchTrial{Requests: 1000, Errors: 9, Latency: 0.1}, true},
+		{searchTrial{Requests: 100, Errors: 1, Latency: 0.1}, false},
+		{searchTrial{Requests: 100, Errors: 0, Latency: 0.2}, false},
+		{searchTrial{Requests: 0}, false},
+	}
+
+	for _, tc := range tests {
+		if got := o.Met(tc.trial); got != tc.want {
+			t.Errorf("%+v: got: %t; want: %t", tc.trial, got, tc.want)
+		}
+	}
+
+	// Without an error rate, any error breaches
+	if (slo{}).Met(searchTrial{Requests: 1000, Errors: 1}) {
+		t.Errorf("got: met with errors; want: breached")
+	}
+}
+
+func TestSearchRates(t *testing.T) {
+	// An endpoint which sustains up to 1000 requests per second
+	var result searchResult
+	var rates []float64
+	rate, ok := 100.0, true
+	for ok {
+		rates = append(rates, rate)
+		rate, ok = result.next(searchTrial{Rate: rate, Met: rate <= 1000}, 100)
+	}
+
+	if result.Max > 1000 || result.Max < 1000*(1-searchPrecision) || result.Breached <= 1000 {
+		t.Errorf("got: max %g, breached %g; want a max just below 1000", result.Max, result.Breached)
+	}
+	if want := []float64{100, 200, 400, 800, 1600, 1200}; len(rates) < len(want) || rates[4] != want[4] || rates[5] != want[5] {
+		t.Errorf("got rates: %v; want to start with: %v", rates, want)
+	}
+}
+
+func TestSearchBreachedAtStart(t *testing.T) {
+	// Rates below the start aren't searched
+	var result searchResult
+	if rate, ok := result.next(searchTrial{Rate: 100}, 100); ok {
+		t.Errorf("got: next rate %g; want the search to stop", rate)
+	}
+	if result.Max != 0 || result.Breached != 100 {
+		t.Errorf("got: max %g, breached %g; want max 0, breached 100", result.Max, result.Breached)
+	}
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	clients, err := NewClients([]string{broken.URL}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Repeat(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`+"\n", 10000)
+	s, err := newSearcher(bufio.NewScanner(strings.NewReader(lines)), "errors<1%", "100/s", "50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan Response, 10000)
+	if err := s.Run(context.Background(), clients, out); err != nil {
+		t.Fatal(err)
+	}
+	if r := s.Results()[0]; r.Max != 0 || r.Breached != 100 || len(r.Trials) != 1 || r.Err != nil {
+		t.Errorf("got: max %g, breached %g, %d trials, %v; want a single breached trial", r.Max, r.Breached, len(r.Trials), r.Err)
+	}
+}
+
+func TestSearchTrialEnd(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer good.Close()
+
+	clients, err := NewClients([]string{good.URL}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Requests are a minute apart, so trials don't wait for the next one
+	lines := strings.Repeat(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`+"\n", 3)
+	s, err := newSearcher(bufio.NewScanner(strings.NewReader(lines)), "errors<1%", "1/m", "50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan Response, 10)
+	started := time.Now()
+	if err := s.Run(context.Background(), clients, out); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("search took %s; want trials to end after %s", elapsed, s.Duration)
+	}
+	if r := s.Results()[0]; len(r.Trials) != 3 || r.Err != errSourceDone {
+		t.Errorf("got: %d trials, %v; want 3 trials until the source ran out", len(r.Trials), r.Err)
+	}
+}
+
+func TestSearchCloses(t *testing.T) {
+	// Searching doesn't leave websocket connections open
+	srv, open := wsConnServer(t, echoResult(`"0x1"`))
+	defer srv.Close()
+
+	clients, err := NewClients([]string{"ws" + strings.TrimPrefix(srv.URL, "http")}, 2, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Repeat(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`+"\n", 10)
+	s, err := newSearcher(bufio.NewScanner(strings.NewReader(lines)), "errors<1%", "100/s", "50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(context.Background(), clients, make(chan Response, 100)); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, open)
+}
+
+func TestSearch(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer good.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	clients, err := NewClients([]string{good.URL, broken.URL}, 4, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Repeat(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`+"\n", 1000)
+	s, err := newSearcher(bufio.NewScanner(strings.NewReader(lines)), "p99<1s,errors<1%", "100/s", "50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan Response)
+	responses := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range out {
+			responses += 1
+		}
+	}()
+	if err := s.Run(context.Background(), clients, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	<-done
+
+	results := s.Results()
+	if r := results[0]; r.Max < 100 || r.Breached != 0 || r.Err != errSourceDone {
+		t.Errorf("good endpoint got: max %g, breached %g, %v; want unbreached until the source ran out", r.Max, r.Breached, r.Err)
+	}
+	if r := results[1]; r.Max != 0 || r.Breached == 0 {
+		t.Errorf("broken endpoint got: max %g, breached %g; want no rate met", r.Max, r.Breached)
+	}
+	if total := clients[0].Stats.numTotal + clients[1].Stats.numTotal; total != responses || total == 0 {
+		t.Errorf("got: %d counted requests, %d responses; want equal", total, responses)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/sync/errgroup"
+)
+
+// subscribeParams parses the --subscribe option into eth_subscribe params. It
+// can be a subscription name like "newHeads", or a JSON array of params.
+func subscribeParams(s string) (json.RawMessage, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		var params []json.RawMessage
+		if err := json.Unmarshal([]byte(s), &params); err != nil {
+			return nil, fmt.Errorf("invalid subscription params: %w", err)
+		}
+		return json.RawMessage(s), nil
+	}
+	return json.Marshal([]string{s})
+}
+
+// notificationKey returns the key used to align the same notification across
+// endpoints: the block hash for newHeads, block hash and log index for logs,
+// and the value itself for everything else (like pending transaction hashes).
+func notificationKey(result json.RawMessage) string {
+	var obj struct {
+		Hash      string `json:"hash"`
+		BlockHash string `json:"blockHash"`
+		LogIndex  string `json:"logIndex"`
+		Removed   bool   `json:"removed"`
+	}
+	if err := json.Unmarshal(result, &obj); err == nil {
+		if obj.BlockHash != "" && obj.LogIndex != "" {
+			return fmt.Sprintf("log:%s:%s:%t", strings.ToLower(obj.BlockHash), strings.ToLower(obj.LogIndex), obj.Removed)
+		}
+		if obj.Hash != "" {
+			return "hash:" + strings.ToLower(obj.Hash)
+		}
+	}
+
+	var s string
+	if err := json.Unmarshal(result, &s); err == nil {
+		return "value:" + strings.ToLower(s)
+	}
+	return "raw:" + string(result)
+}
+
+type alignedNotification struct {
+	id    requestID
+	first time.Time // When the first endpoint delivered it
+	seen  []bool    // Indexed by client
+	count int       // Number of clients that delivered it
+}
+
+// notificationAligner matches notifications pushed by each client by their
+// key, and emits them as responses so they can be compared like any other
+// response. The lag behind the first endpoint to deliver the notification is
+// used as the elapsed time.
+type notificationAligner struct {
+	Clients Clients
+	Limit   int // Stop accepting new notifications after this many, 0 is unlimited.
+
+	out chan<- Response
+
+	mu       sync.Mutex
+	keys     map[string]*alignedNotification
+	nextID   requestID
+	pending  int           // Number of notifications not delivered by every client yet
+	full     chan struct{} // Closed when the limit is reached
+	complete chan struct{} // Closed when the limit is reached and nothing is pending
+}
+
+func newNotificationAligner(clients Clients, limit int, out chan<- Response) *notificationAligner {
+	return &notificationAligner{
+		Clients:  clients,
+		Limit:    limit,
+		out:      out,
+		keys:     map[string]*alignedNotification{},
+		full:     make(chan struct{}),
+		complete: make(chan struct{}),
+	}
+}
+
+func (a *notificationAligner) isFull() bool {
+	return a.Limit > 0 && int(a.nextID) >= a.Limit
+}
+
+// Deliver records a notification received by the client with the given index.
+func (a *notificationAligner) Deliver(ctx context.Context, i int, req *Request, result json.RawMessage, at time.Time) error {
+	client := a.Clients[i]
+	key := notificationKey(result)
+
+	a.mu.Lock()
+	n, ok := a.keys[key]
+	if !ok {
+		if a.isFull() {
+			a.mu.Unlock()
+			return nil
+		}
+		a.nextID += 1
+		n = &alignedNotification{
+			id:    a.nextID,
+			first: at,
+			seen:  make([]bool, len(a.Clients)),
+		}
+		a.keys[key] = n
+		a.pending += 1
+		if a.isFull() {
+			close(a.full)
+		}
+	}
+	if n.seen[i] {
+		a.mu.Unlock()
+		client.Stats.CountDuplicate()
+		return nil
+	}
+	n.seen[i] = true
+	n.count += 1
+	// Late if a newer notification was already delivered first by someone else
+	late := n.id < a.nextID
+	if n.count == len(a.Clients) {
+		a.pending -= 1
+		if a.pending == 0 && a.isFull() {
+			close(a.complete)
+		}
+	}
+	a.mu.Unlock()
+
+	resp := Response{
+		client:  client,
+		Request: req,
+
+		ID:      n.id,
+		Body:    result,
+		Elapsed: at.Sub(n.first),
+	}
+	client.Stats.CountNotification(resp.Elapsed, late)
+
+	select {
+	case a.out <- resp:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Finalize counts the notifications each client never delivered.
+func (a *notificationAligner) Finalize() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, n := range a.keys {
+		if n.count == len(a.Clients) {
+			continue
+		}
+		for i, seen := range n.seen {
+			if !seen {
+				a.Clients[i].Stats.CountMissing()
+			}
+		}
+	}
+}
+
+// subscribe opens the same subscription on every client and emits the aligned
+// notifications until the context is done, or until limit notifications were
+// received and every client had up to timeout to deliver them.
+func subscribe(ctx context.Context, clients Clients, params json.RawMessage, out chan<- Response, limit int, timeout time.Duration) error {
+	a := newNotificationAligner(clients, limit, out)
+	defer a.Finalize()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	g, ctx := errgroup.WithContext(ctx)
+
+	for i, c := range clients {
+		i, c := i, c
+		c.Stats.Subscription = true
+		g.Go(func() error {
+			return subscribeClient(ctx, i, c, params, a)
+		})
+	}
+
+	g.Go(func() error {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-a.full:
+		}
+		logger.Info().Msgf("stopping subscription after %d notifications", limit)
+
+		var straggle <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			straggle = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-a.complete:
+		case <-straggle:
+		}
+		cancel()
+		return nil
+	})
+
+	err := g.Wait()
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// subscribeClient subscribes on a single client's websocket endpoint and
+// delivers its notifications to the aligner.
+func subscribeClient(ctx context.Context, i int, client *Client, params json.RawMessage, a *notificationAligner) error {
+	u, err := url.Parse(client.Endpoint)
+	if err != nil {
+		return err
+	}
+	scheme := strings.Split(u.Scheme, "+")[0]
+	if scheme != "ws" && scheme != "wss" {
+		return fmt.Errorf("subscriptions require a websocket endpoint: %s", client.Endpoint)
+	}
+	u.Scheme = scheme
+
+	conn, err := dialWebsocket(u.String(), client.Header)
+	if err != nil {
+		return err
+	}
+	go func() {
+		// Unblock reads on shutdown
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	line, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "eth_subscribe",
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	req := &Request{
+		client:    client,
+		Line:      line,
+		Timestamp: time.Now(),
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, line); err != nil {
+		return err
+	}
+
+	var subscription string
+	for {
+		_, message, err := conn.ReadMessage()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+
+		var msg struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+			Params struct {
+				Subscription string          `json:"subscription"`
+				Result       json.RawMessage `json:"result"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			logger.Debug().Str("endpoint", client.Endpoint).Err(err).Msg("skipping invalid subscription message")
+			continue
+		}
+
+		if subscription == "" {
+			if string(msg.ID) != "1" {
+				continue
+			}
+			if len(msg.Error) > 0 {
+				return fmt.Errorf("failed to subscribe on %s: %s", client.Endpoint, msg.Error)
+			}
+			if err := json.Unmarshal(msg.Result, &subscription); err != nil || subscription == "" {
+				return fmt.Errorf("failed to subscribe on %s: unexpected result %s", client.Endpoint, msg.Result)
+			}
+			logger.Debug().Str("endpoint", client.Endpoint).Str("subscription", subscription).Msg("subscribed")
+			continue
+		}
+
+		if msg.Method != "eth_subscription" || msg.Params.Subscription != subscription {
+			continue
+		}
+		if err := a.Deliver(ctx, i, req, msg.Params.Result, now); err != nil {
+			return err
+		}
+	}
+}
+
+func (stats *clientStats) CountNotification(lag time.Duration, late bool) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.numTotal += 1
+	stats.timing.Add(lag.Seconds())
+	if late {
+		stats.numLate += 1
+	}
+}
+
+func (stats *clientStats) CountDuplicate() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.numDuplicates += 1
+}
+
+func (stats *clientStats) CountMissing() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.numMissing += 1
+}
+
+func (stats *clientStats) renderNotifications(w io.Writer) error {
+	fmt.Fprintf(w, "\n   Notifications: %d received, %d late, %d duplicated, %d missing\n", stats.numTotal, stats.numLate, stats.numDuplicates, stats.numMissing)
+	if stats.numTotal == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "   Lag:        %0.4fs avg, %0.4fs min, %0.4fs max\n", stats.timing.Average(), stats.timing.Min(), stats.timing.Max())
+	renderPercentiles(w, &stats.timing)
+	return nil
+}
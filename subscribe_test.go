@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationKey(t *testing.T) {
+	tests := []struct {
+		result string
+		want   string
+	}{
+		{`{"number":"0x1","hash":"0xABC"}`, `hash:0xabc`},
+		{`{"blockHash":"0xabc","logIndex":"0x2","transactionHash":"0xdef"}`, `log:0xabc:0x2:false`},
+		{`{"blockHash":"0xabc","logIndex":"0x2","removed":true}`, `log:0xabc:0x2:true`},
+		{`"0xDEF"`, `value:0xdef`},
+		{`{"syncing":false}`, `raw:{"syncing":false}`},
+	}
+
+	for _, tc := range tests {
+		if got := notificationKey(json.RawMessage(tc.result)); got != tc.want {
+			t.Errorf("%s: got: %q; want: %q", tc.result, got, tc.want)
+		}
+	}
+}
+
+func TestNotificationAligner(t *testing.T) {
+	clients, err := NewClients([]string{
+		"noop://foo",
+		"noop://bar",
+	}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	out := make(chan Response, 10)
+	a := newNotificationAligner(clients, 3, out)
+
+	start := time.Now()
+	deliver := func(i int, result string, offset time.Duration) {
+		if err := a.Deliver(ctx, i, nil, json.RawMessage(result), start.Add(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliver(0, `"0x1"`, 0)
+	deliver(0, `"0x2"`, time.Second)
+	deliver(1, `"0x1"`, 2*time.Second) // Late: 0x2 was already delivered
+	deliver(1, `"0x1"`, 3*time.Second) // Duplicate
+	deliver(1, `"0x3"`, 4*time.Second)
+	deliver(1, `"0x4"`, 5*time.Second) // Over the limit, ignored
+
+	select {
+	case <-a.full:
+	default:
+		t.Error("aligner is not full")
+	}
+	deliver(0, `"0x3"`, 6*time.Second)
+	a.Finalize()
+	close(out)
+
+	r := report{Clients: clients}
+	r.init()
+	for resp := range out {
+		r.handle(resp)
+	}
+
+	if got, want := r.completed, 2; got != want {
+		t.Errorf("completed got: %d; want: %d", got, want)
+	}
+	if got, want := len(r.pendingResponses), 1; got != want {
+		t.Errorf("pending got: %d; want: %d", got, want)
+	}
+
+	foo, bar := &clients[0].Stats, &clients[1].Stats
+	if got, want := foo.numMissing, 0; got != want {
+		t.Errorf("foo missing got: %d; want: %d", got, want)
+	}
+	if got, want := bar.numMissing, 1; got != want {
+		t.Errorf("bar missing got: %d; want: %d", got, want)
+	}
+	if got, want := bar.numDuplicates, 1; got != want {
+		t.Errorf("bar duplicates got: %d; want: %d", got, want)
+	}
+	if got, want := bar.numLate, 1; got != want {
+		t.Errorf("bar late got: %d; want: %d", got, want)
+	}
+	if got, want := bar.timing.Max(), 2.0; got != want {
+		t.Errorf("bar max lag got: %0.4f; want: %0.4f", got, want)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	heads := []string{"0xa", "0xb"}
+	srv := wsServer(t, func(msg []byte) [][]byte {
+		out := [][]byte{[]byte(`{"jsonrpc":"2.0","id":1,"result":"0x99"}`)}
+		for _, hash := range heads {
+			out = append(out, []byte(`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0x99","result":{"hash":"`+hash+`"}}}`))
+		}
+		return out
+	})
+	defer srv.Close()
+
+	endpoint := "ws" + strings.TrimPrefix(srv.URL, "http")
+	clients, err := NewClients([]string{endpoint, endpoint}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := subscribeParams("newHeads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan Response, 10)
+	if err := subscribe(context.Background(), clients, params, out, 2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	r := report{Clients: clients}
+	r.init()
+	for resp := range out {
+		r.handle(resp)
+	}
+	if got, want := r.completed, 2; got != want {
+		t.Errorf("completed got: %d; want: %d", got, want)
+	}
+	if got, want := r.mismatched, 0; got != want {
+		t.Errorf("mismatched got: %d; want: %d", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// phase is a part of an HTTP request, timed with httptrace.
+type phase int
+
+const (
+	phaseDNS       phase = iota // Resolving the host of a new connection
+	phaseConnect                // Connecting over TCP
+	phaseTLS                    // TLS handshake of a new connection
+	phaseFirstByte              // From writing the request until the first byte of the response
+	phaseDownload               // From the first byte until the body is read
+	numPhases
+)
+
+var phaseNames = [numPhases]string{"dns", "connect", "tls", "first_byte", "download"}
+
+func (p phase) String() string {
+	return phaseNames[p]
+}
+
+// phases are the durations of the phases of a request. Connection phases only
+// happen for requests which didn't reuse a connection.
+type phases struct {
+	Durations [numPhases]time.Duration
+	Done      [numPhases]bool
+}
+
+// Traced is a type of Transport that times the phases of each request, like
+// connecting and waiting for the first byte.
+type Traced interface {
+	// Phases returns the phases of the last request sent. Phases of failed
+	// requests are those which were done before it failed.
+	Phases() phases
+}
+
+// phaseTracer collects the phases of a request from httptrace hooks, which
+// can be called from other goroutines while dialing.
+type phaseTracer struct {
+	mu      sync.Mutex
+	started [numPhases]time.Time
+	phases  phases
+}
+
+func (pt *phaseTracer) begin(p phase) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.started[p].IsZero() {
+		pt.started[p] = time.Now()
+	}
+}
+
+func (pt *phaseTracer) end(p phase) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.started[p].IsZero() || pt.phases.Done[p] {
+		return
+	}
+	pt.phases.Durations[p] = time.Since(pt.started[p])
+	pt.phases.Done[p] = true
+}
+
+// Phases returns the phases which were done so far.
+func (pt *phaseTracer) Phases() phases {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	return pt.phases
+}
+
+func (pt *phaseTracer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { pt.begin(phaseDNS) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { pt.end(phaseDNS) },
+		ConnectStart:      func(network, addr string) { pt.begin(phaseConnect) },
+		ConnectDone:       func(network, addr string, err error) { pt.end(phaseConnect) },
+		TLSHandshakeStart: func() { pt.begin(phaseTLS) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { pt.end(phaseTLS) },
+		WroteRequest:      func(httptrace.WroteRequestInfo) { pt.begin(phaseFirstByte) },
+		GotFirstResponseByte: func() {
+			pt.end(phaseFirstByte)
+			pt.begin(phaseDownload)
+		},
+	}
+}
+
+// CountPhases counts the phases of a request which were done, including for
+// failed requests, like the timing stats.
+func (stats *clientStats) CountPhases(p phases) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	for i := range stats.phases {
+		if !p.Done[i] {
+			continue
+		}
+		h := &stats.phases[i]
+		if h.Len() == 0 {
+			h.Precision = stats.timing.Precision
+		}
+		h.Add(p.Durations[i].Seconds())
+	}
+}
+
+func (stats *clientStats) renderPhases(w io.Writer) {
+	counted := false
+	for i := range stats.phases {
+		counted = counted || stats.phases[i].Len() > 0
+	}
+	if !counted {
+		return
+	}
+
+	width := len("first_byte")
+	fmt.Fprintf(w, "\n   %-*s  %8s  %8s  %8s  %8s  %8s\n", width, "Phases:", "Requests", "Avg", "p50", "p95", "p99")
+	for i := range stats.phases {
+		h := &stats.phases[i]
+		if h.Len() == 0 {
+			continue
+		}
+		percentiles := h.Percentiles(50, 95, 99)
+		fmt.Fprintf(w, "     %-*s%8d  %7.4fs  %7.4fs  %7.4fs  %7.4fs\n", width, phase(i), h.Len(), h.Average(), percentiles[0], percentiles[1], percentiles[2])
+	}
+}
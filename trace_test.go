@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPTransportPhases(t *testing.T) {
+	delay := 20 * time.Millisecond
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer srv.Close()
+
+	tr, err := NewTransport(srv.URL, 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.(*httpTransport).Client.Transport = srv.Client().Transport
+
+	req := Request{Line: []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`)}
+	resp := req.Do(tr)
+	if resp.Err != nil {
+		t.Fatal(resp.Err)
+	}
+	if resp.Phases == nil {
+		t.Fatal("got: no phases; want phases of the http transport")
+	}
+	// The server is an IP address, so there's no DNS lookup
+	for p, want := range []bool{false, true, true, true, true} {
+		if got := resp.Phases.Done[p]; got != want {
+			t.Errorf("first request %s done got: %t; want: %t", phase(p), got, want)
+		}
+	}
+	if got := resp.Phases.Durations[phaseFirstByte]; got < delay {
+		t.Errorf("first byte got: %s; want at least %s", got, delay)
+	}
+
+	// The connection is reused
+	resp = req.Do(tr)
+	if resp.Err != nil {
+		t.Fatal(resp.Err)
+	}
+	for p, want := range []bool{false, false, false, true, true} {
+		if got := resp.Phases.Done[p]; got != want {
+			t.Errorf("second request %s done got: %t; want: %t", phase(p), got, want)
+		}
+	}
+
+	// A request which can't be built doesn't report the previous phases
+	if err := tr.(Modal).Mode("get"); err != nil {
+		t.Fatal(err)
+	}
+	bad := Request{Line: []byte("%zz")}
+	resp = bad.Do(tr)
+	if resp.Err == nil {
+		t.Fatal("got: no error; want an invalid url")
+	}
+	if *resp.Phases != (phases{}) {
+		t.Errorf("got: %+v; want no phases for a request which wasn't sent", *resp.Phases)
+	}
+
+	if resp := req.Do(&noopTransport{}); resp.Phases != nil {
+		t.Errorf("got: %+v; want no phases for an untraced transport", resp.Phases)
+	}
+}
+
+func TestRenderPhases(t *testing.T) {
+	var stats clientStats
+	var p phases
+	p.Durations[phaseFirstByte], p.Done[phaseFirstByte] = 10*time.Millisecond, true
+	stats.CountPhases(p)
+	p.Durations[phaseConnect], p.Done[phaseConnect] = time.Millisecond, true
+	stats.CountPhases(p)
+
+	var buf bytes.Buffer
+	stats.renderPhases(&buf)
+	out := buf.String()
+	for _, want := range []string{"Phases:", "connect", "first_byte"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got: %q; want it to contain %q", out, want)
+		}
+	}
+	if strings.Contains(out, "dns") {
+		t.Errorf("got: %q; want no phases which weren't done", out)
+	}
+	if got := stats.JSON().Phases; got["first_byte"].Requests != 2 || got["connect"].Requests != 1 {
+		t.Errorf("got: %+v; want 2 first_byte and 1 connect", got)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"path"
 	"strings"
@@ -16,8 +17,9 @@ import (
 
 // NewTransport creates a transport that supports the given endpoint. The
 // endpoint is a URI with a scheme and an optional mode, for example
-// "https+get://infura.io/".
-func NewTransport(endpoint string, timeout time.Duration) (Transport, error) {
+// "https+get://infura.io/". The header is sent with every request, or with the
+// handshake for websockets.
+func NewTransport(endpoint string, timeout time.Duration, header http.Header) (Transport, error) {
 	url, err := url.Parse(endpoint)
 	if err != nil {
 		return nil, err
@@ -33,6 +35,7 @@ func NewTransport(endpoint string, timeout time.Duration) (Transport, error) {
 		t = &httpTransport{
 			Client:      http.Client{Timeout: timeout},
 			endpoint:    url.String(),
+			header:      header,
 			contentType: "application/json",
 			bodyReader: func(body io.ReadCloser) ([]byte, error) {
 				defer body.Close()
@@ -40,13 +43,16 @@ func NewTransport(endpoint string, timeout time.Duration) (Transport, error) {
 			},
 		}
 	case "ws", "wss":
-		conn, _, err := websocket.DefaultDialer.Dial(url.String(), nil)
-		if err != nil {
-			return nil, fmt.Errorf("Got: %s when connecting to ws", err)
+		url.Scheme = scheme
+		wt := &websocketTransport{
+			endpoint: url.String(),
+			header:   header,
+			timeout:  timeout,
 		}
-		t = &websocketTransport{
-			ws: conn,
+		if err := wt.dial(); err != nil {
+			return nil, err
 		}
+		t = wt
 	case "noop":
 		t = &noopTransport{}
 	default:
@@ -67,6 +73,27 @@ func NewTransport(endpoint string, timeout time.Duration) (Transport, error) {
 type Modal interface {
 	Mode(string) error
 }
+
+// Shared is a type of Transport that is safe for concurrent use, so a single
+// instance can be shared by all of a client's goroutines.
+type Shared interface {
+	Shared() bool
+}
+
+func isShared(t Transport) bool {
+	s, ok := t.(Shared)
+	return ok && s.Shared()
+}
+
+// closeTransport closes the connection of a transport which holds one open,
+// like websockets. HTTP transports share a pool of connections, which is left
+// as is.
+func closeTransport(t Transport) {
+	if c, ok := t.(io.Closer); ok {
+		c.Close()
+	}
+}
+
 type Transport interface {
 	// TODO: Add context?
 	// TODO: Should this be: Do(Request) (Response, error)?
@@ -78,11 +105,19 @@ type httpTransport struct {
 
 	contentType string
 	endpoint    string
+	header      http.Header
 
 	getHost string
 	getPath string
 
 	bodyReader func(io.ReadCloser) ([]byte, error)
+
+	phases phases // Of the last request
+}
+
+// Phases returns the phases of the last request, so httpTransport is Traced.
+func (t *httpTransport) Phases() phases {
+	return t.phases
 }
 
 func (t *httpTransport) Mode(m string) error {
@@ -107,44 +142,168 @@ func (t *httpTransport) Mode(m string) error {
 }
 
 func (t *httpTransport) Send(body []byte) ([]byte, error) {
-	var resp *http.Response
+	// Requests which fail before they're sent have no phases
+	t.phases = phases{}
+
+	var req *http.Request
 	var err error
 	if t.getHost != "" {
 		url := t.getHost + path.Join(t.getPath, string(body))
-		resp, err = t.Client.Get(url)
+		req, err = http.NewRequest(http.MethodGet, url, nil)
 	} else {
-		resp, err = t.Client.Post(t.endpoint, t.contentType, bytes.NewReader(body))
+		req, err = http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", t.contentType)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range t.header {
+		req.Header[key] = values
+	}
+	if host := t.header.Get("Host"); host != "" {
+		req.Host = host
 	}
+
+	tracer := &phaseTracer{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), tracer.trace()))
+	defer func() {
+		t.phases = tracer.Phases()
+	}()
+
+	resp, err := t.Client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("bad status code: %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, statusError{resp.StatusCode}
 	}
 	if t.bodyReader == nil {
 		resp.Body.Close()
+		tracer.end(phaseDownload)
 		return nil, nil
 	}
 	// TODO: Avoid reading the whole body into memory
-	return t.bodyReader(resp.Body)
+	data, err := t.bodyReader(resp.Body)
+	tracer.end(phaseDownload)
+	return data, err
+}
+
+// statusError is returned for HTTP responses with an error status code.
+type statusError struct {
+	StatusCode int
+}
+
+func (err statusError) Error() string {
+	return fmt.Sprintf("bad status code: %d", err.StatusCode)
 }
 
 type websocketTransport struct {
-	ws *websocket.Conn
+	ws       *websocket.Conn
+	endpoint string
+	header   http.Header
+	timeout  time.Duration
+
+	pipeline *websocketPipeline
 }
 
-func (t *websocketTransport) Send(body []byte) ([]byte, error) {
-	err := t.ws.WriteMessage(websocket.TextMessage, body)
+func (t *websocketTransport) Mode(m string) error {
+	switch strings.ToLower(m) {
+	case "pipeline":
+		// The pipeline takes over the connection
+		t.pipeline = &websocketPipeline{
+			ws:       t.ws,
+			endpoint: t.endpoint,
+			header:   t.header,
+			timeout:  t.timeout,
+		}
+		t.ws = nil
+		t.pipeline.start()
+	default:
+		return fmt.Errorf("invalid mode for websocket transport: %s", m)
+	}
+	return nil
+}
+
+func (t *websocketTransport) Shared() bool {
+	return t.pipeline != nil
+}
+
+func dialWebsocket(endpoint string, header http.Header) (*websocket.Conn, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint, header)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Got: %s when connecting to ws", err)
 	}
-	_, message, err := t.ws.ReadMessage()
-	_ = message
+	return conn, nil
+}
+
+func (t *websocketTransport) dial() error {
+	conn, err := dialWebsocket(t.endpoint, t.header)
 	if err != nil {
+		return err
+	}
+	t.ws = conn
+	return nil
+}
+
+// reset closes the connection so that it's redialed on the next send. Once a
+// read or write fails (including deadlines), the connection is unusable.
+func (t *websocketTransport) reset() {
+	if t.ws != nil {
+		t.ws.Close()
+		t.ws = nil
+	}
+}
+
+// Close closes the connection, and stops the pipeline if there is one.
+func (t *websocketTransport) Close() error {
+	if t.pipeline != nil {
+		return t.pipeline.Close()
+	}
+	t.reset()
+	return nil
+}
+
+// Send writes the body and waits for the response with the same JSON-RPC id.
+// Messages with other ids (such as late responses to requests that timed out)
+// are skipped. Bodies without an id are answered by the next message.
+func (t *websocketTransport) Send(body []byte) ([]byte, error) {
+	if t.pipeline != nil {
+		return t.pipeline.Send(body)
+	}
+	if t.ws == nil {
+		if err := t.dial(); err != nil {
+			return nil, err
+		}
+	}
+	if t.timeout > 0 {
+		deadline := time.Now().Add(t.timeout)
+		t.ws.SetWriteDeadline(deadline)
+		t.ws.SetReadDeadline(deadline)
+	}
+
+	if err := t.ws.WriteMessage(websocket.TextMessage, body); err != nil {
+		t.reset()
 		return nil, err
 	}
 
-	return nil, nil
+	want, hasID := jsonrpcID(body)
+	for {
+		_, message, err := t.ws.ReadMessage()
+		if err != nil {
+			t.reset()
+			return nil, err
+		}
+		if !hasID {
+			return message, nil
+		}
+		if got, _ := jsonrpcID(message); got == want {
+			return message, nil
+		}
+		logger.Debug().Str("endpoint", t.endpoint).Str("want", want).Msg("skipping websocket message with unexpected id")
+	}
 }
 
 type noopTransport struct{}
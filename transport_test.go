@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsServer starts a websocket server which calls handler for every message
+// received and writes back each of the returned messages.
+func wsServer(t *testing.T, handler func(msg []byte) [][]byte) *httptest.Server {
+	srv, _ := wsConnServer(t, handler)
+	return srv
+}
+
+// wsConnServer is like wsServer, and also counts its open connections.
+func wsConnServer(t *testing.T, handler func(msg []byte) [][]byte) (*httptest.Server, *int32) {
+	upgrader := websocket.Upgrader{}
+	var open int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Counted before the handshake, so it's open once the client is
+		atomic.AddInt32(&open, 1)
+		defer atomic.AddInt32(&open, -1)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			for _, out := range handler(msg) {
+				if err := conn.WriteMessage(websocket.TextMessage, out); err != nil {
+					return
+				}
+			}
+		}
+	})), &open
+}
+
+// waitClosed waits for the open connections of a wsConnServer to be closed.
+func waitClosed(t *testing.T, open *int32) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(open) > 0 {
+		if time.Now().After(deadline) {
+			t.Errorf("got: %d open connections; want all closed", atomic.LoadInt32(open))
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// echoResult is a wsServer handler which answers every request with the result.
+func echoResult(result string) func(msg []byte) [][]byte {
+	return func(msg []byte) [][]byte {
+		id, _ := jsonrpcID(msg)
+		return [][]byte{[]byte(`{"jsonrpc":"2.0","id":` + id + `,"result":` + result + `}`)}
+	}
+}
+
+func TestWebsocketTransport(t *testing.T) {
+	srv := wsServer(t, func(msg []byte) [][]byte {
+		id, _ := jsonrpcID(msg)
+		return [][]byte{
+			[]byte(`{"jsonrpc":"2.0","id":999,"result":"stale"}`),
+			[]byte(`{"jsonrpc":"2.0","id":` + id + `,"result":"fresh"}`),
+		}
+	})
+	defer srv.Close()
+
+	tr, err := NewTransport("ws"+strings.TrimPrefix(srv.URL, "http"), 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"1", `"foo"`} {
+		got, err := tr.Send([]byte(`{"jsonrpc":"2.0","id":` + id + `,"method":"eth_blockNumber"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []byte(`{"jsonrpc":"2.0","id":` + id + `,"result":"fresh"}`); !bytes.Equal(got, want) {
+			t.Errorf("got: %s; want: %s", got, want)
+		}
+	}
+}
+
+func TestJSONRPCID(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+		ok   bool
+	}{
+		{`{"id":1,"method":"foo"}`, `1`, true},
+		{`{"id":"abc","result":null}`, `"abc"`, true},
+		{`{"method":"foo"}`, ``, false},
+		{`{"id":null}`, ``, false},
+		{`[{"id":2},{"id":1},{"method":"notify"}]`, `[1,2]`, true},
+		{`not json`, ``, false},
+	}
+
+	for _, tc := range tests {
+		got, ok := jsonrpcID([]byte(tc.body))
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%s: got: %q %t; want: %q %t", tc.body, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestWebsocketPipeline(t *testing.T) {
+	// Hold the first request, and reply to both in reverse order
+	var held []byte
+	srv := wsServer(t, func(msg []byte) [][]byte {
+		id, _ := jsonrpcID(msg)
+		var req struct {
+			Params []string `json:"params"`
+		}
+		if err := json.Unmarshal(msg, &req); err != nil {
+			t.Error(err)
+		}
+		resp := []byte(`{"jsonrpc":"2.0","id":` + id + `,"result":"` + req.Params[0] + `"}`)
+		if held == nil {
+			held = resp
+			return nil
+		}
+		return [][]byte{resp, held}
+	})
+	defer srv.Close()
+
+	tr, err := NewTransport("ws+pipeline"+strings.TrimPrefix(srv.URL, "http"), 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isShared(tr) {
+		t.Fatal("pipelined transport is not shared")
+	}
+
+	var wg sync.WaitGroup
+	for _, param := range []string{"foo", "bar"} {
+		param := param
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := tr.Send([]byte(`{"jsonrpc":"2.0","id":1,"method":"echo","params":["` + param + `"]}`))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// The caller's id is restored on the response
+			var resp struct {
+				ID     json.RawMessage `json:"id"`
+				Result string          `json:"result"`
+			}
+			if err := json.Unmarshal(got, &resp); err != nil {
+				t.Error(err)
+				return
+			}
+			if string(resp.ID) != "1" || resp.Result != param {
+				t.Errorf("got: %s; want id: 1, result: %s", got, param)
+			}
+		}()
+	}
+	wg.Wait()
+}
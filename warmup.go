@@ -0,0 +1,43 @@
+package main
+
+import (
+	"time"
+)
+
+// warmup marks the requests at the start of a run which warm up connections
+// and caches, so that they're excluded from the stats.
+type warmup struct {
+	Requests int           // Number of requests to warm up with
+	Duration time.Duration // Or how long to warm up for, from the first request
+
+	start time.Time
+	n     int
+}
+
+// parseWarmup parses a number of requests or a duration, like --stop-after.
+func parseWarmup(s string) (*warmup, error) {
+	if s == "" {
+		return nil, nil
+	}
+	d, n, err := parseStopAfter(s)
+	if err != nil {
+		return nil, err
+	}
+	if d <= 0 && n <= 0 {
+		return nil, nil
+	}
+	return &warmup{Requests: n, Duration: d}, nil
+}
+
+// Next returns true if the next request, sent at the time, is part of the
+// warm-up.
+func (w *warmup) Next(at time.Time) bool {
+	if w.start.IsZero() {
+		w.start = at
+	}
+	if w.Duration > 0 {
+		return at.Sub(w.start) < w.Duration
+	}
+	w.n += 1
+	return w.n <= w.Requests
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	if w, err := parseWarmup(""); w != nil || err != nil {
+		t.Errorf("got: %+v %v; want no warm-up", w, err)
+	}
+	if _, err := parseWarmup("forever"); err == nil {
+		t.Errorf("got: no error; want an invalid warm-up")
+	}
+
+	w, err := parseWarmup("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []bool
+	for i := 0; i < 4; i++ {
+		got = append(got, w.Next(time.Now()))
+	}
+	if want := []bool{true, true, false, false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v; want: %v", got, want)
+	}
+
+	w, err = parseWarmup("20ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	if !w.Next(started) {
+		t.Errorf("got: not warming up; want warming up at the start")
+	}
+	if !w.Next(started.Add(w.Duration - time.Millisecond)) {
+		t.Errorf("got: not warming up; want warming up until %s", w.Duration)
+	}
+	if w.Next(started.Add(w.Duration)) {
+		t.Errorf("got: warming up; want done after %s", w.Duration)
+	}
+}
+
+func TestPumpWarmup(t *testing.T) {
+	clients, err := NewClients([]string{"noop://foo", "noop://bar"}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses := make(chan Response, 100)
+	ctx := context.Background()
+	done := make(chan error)
+	go func() {
+		done <- clients.Serve(ctx, responses)
+		close(responses)
+	}()
+
+	lines := strings.Repeat(`{"method":"eth_blockNumber"}`+"\n", 10)
+	if err := pump(ctx, bufio.NewScanner(strings.NewReader(lines)), clients, 3, nil, &warmup{Requests: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Warm-up requests don't count towards stopping
+	for _, c := range clients {
+		if c.Stats.numWarmup != 2 || c.Stats.numTotal != 3 {
+			t.Errorf("got: %d warm-up, %d requests; want 2 warm-up, 3 requests", c.Stats.numWarmup, c.Stats.numTotal)
+		}
+	}
+
+	r := report{Clients: clients}
+	r.init()
+	for resp := range responses {
+		r.handle(resp)
+	}
+	if r.warmup != 4 || r.requests != 6 || r.completed != 3 || len(r.pendingResponses) != 0 {
+		t.Errorf("got: %d warm-up, %d requests, %d completed, %d pending; want 4 warm-up, 6 requests, 3 completed", r.warmup, r.requests, r.completed, len(r.pendingResponses))
+	}
+}
+
+func TestPumpScheduledWarmup(t *testing.T) {
+	clients, err := NewClients([]string{"noop://foo"}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses := make(chan Response, 100)
+	ctx := context.Background()
+	done := make(chan error)
+	go func() {
+		done <- clients.Serve(ctx, responses)
+		close(responses)
+	}()
+
+	sched := &schedule{Stages: []stage{{Name: "hold", From: 1000, To: 1000, Duration: time.Second}}}
+	lines := strings.Repeat(`{"method":"eth_blockNumber"}`+"\n", 10)
+	if err := pump(ctx, bufio.NewScanner(strings.NewReader(lines)), clients, 3, sched, &warmup{Requests: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Warm-up requests are paced, but not counted as sent
+	if got := sched.behind.Len(); got != 3 {
+		t.Errorf("got: %d sent; want: 3", got)
+	}
+	if got := sched.sent[0]; got != 3 {
+		t.Errorf("got: %d sent in the stage; want: 3", got)
+	}
+	if sched.n != 5 {
+		t.Errorf("got: %d scheduled; want: 5", sched.n)
+	}
+}
+
+func TestPumpScheduledWarmupDuration(t *testing.T) {
+	clients, err := NewClients([]string{"noop://foo"}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses := make(chan Response, 100)
+	ctx := context.Background()
+	done := make(chan error)
+	go func() {
+		done <- clients.Serve(ctx, responses)
+		close(responses)
+	}()
+
+	// Requests are scheduled at 0, 10 and 20ms during the warm-up
+	sched := &schedule{Interval: 10 * time.Millisecond}
+	lines := strings.Repeat(`{"method":"eth_blockNumber"}`+"\n", 10)
+	if err := pump(ctx, bufio.NewScanner(strings.NewReader(lines)), clients, 3, sched, &warmup{Duration: 25 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if got := clients[0].Stats.numWarmup; got != 3 {
+		t.Errorf("got: %d warm-up; want: 3", got)
+	}
+}
+
+func TestReportWarmup(t *testing.T) {
+	clients, err := NewClients([]string{"noop://foo", "noop://bar"}, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := report{Clients: clients, WarmupCompare: true}
+	r.init()
+	var mismatched int
+	r.MismatchedResponse = func(resps []Response) {
+		mismatched += 1
+	}
+
+	req := &Request{ID: 1, Warmup: true}
+	r.handle(Response{client: clients[0], Request: req, ID: 1, Body: []byte(`"foo"`)})
+	r.handle(Response{client: clients[1], Request: req, ID: 1, Body: []byte(`"bar"`)})
+	if r.warmupMismatched != 1 || mismatched != 1 {
+		t.Errorf("got: %d warm-up mismatches, %d reported; want 1", r.warmupMismatched, mismatched)
+	}
+	if r.requests != 0 || r.mismatched != 0 || r.completed != 0 {
+		t.Errorf("got: %d requests, %d mismatched, %d completed; want warm-up excluded", r.requests, r.mismatched, r.completed)
+	}
+}